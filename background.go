@@ -0,0 +1,59 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // decoders for background images
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// checkBGFit reports whether fit is a known way of fitting a background
+// image.
+func checkBGFit(fit string) error {
+	switch fit {
+	case "stretch", "tile", "center":
+		return nil
+	}
+	return configErrorf("invalid background fit %q (want stretch, tile or center)", fit)
+}
+
+// backgroundImage returns a width x height canvas filled with bg and then
+// the image in the file path, fitted according to fit: stretch scales it
+// to the canvas, tile repeats it from the top left corner and center puts
+// it in the middle at its own size.
+func backgroundImage(path, fit string, width, height int, bg image.Image) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening background image: %w", err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding background image %s: %w", path, err)
+	}
+	if src.Bounds().Empty() {
+		return nil, fmt.Errorf("background image %s is empty", path)
+	}
+
+	dst := createImage(width, height, bg)
+	sb := src.Bounds()
+	switch fit {
+	case "stretch":
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, sb, draw.Over, nil)
+	case "tile":
+		for y := 0; y < height; y += sb.Dy() {
+			for x := 0; x < width; x += sb.Dx() {
+				draw.Draw(dst, sb.Sub(sb.Min).Add(image.Pt(x, y)), src, sb.Min, draw.Over)
+			}
+		}
+	case "center":
+		at := image.Pt((width-sb.Dx())/2, (height-sb.Dy())/2)
+		draw.Draw(dst, sb.Sub(sb.Min).Add(at), src, sb.Min, draw.Over)
+	}
+	return dst, nil
+}
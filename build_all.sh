@@ -21,7 +21,7 @@ for os in "${PLATFORMS[@]}"; do
         fi
 
         echo "Building for $os/$arch..."
-        GOOS=$os GOARCH=$arch go build -o "${BUILD_DIR}/${output_name}" txt2png.go
+        GOOS=$os GOARCH=$arch go build -o "${BUILD_DIR}/${output_name}" ./cmd/txt2png
 
         if [ $? -ne 0 ]; then
             echo "Error building for $os/$arch"
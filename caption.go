@@ -0,0 +1,59 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// captionScale is the size of a caption relative to the main text when
+// Config.CaptionSize is not set.
+const captionScale = 0.4
+
+// captionConfig returns the settings that render the caption of cfg on its
+// own: proportional, on a transparent background, with the fonts of cfg
+// and none of its effects. drawn is the caption color. cfg.Size and
+// cfg.DPI must be final, after fitting; its height follows from them, so
+// Validate checks them before the height to report a bad size as such.
+func captionConfig(cfg Config, drawn color.RGBA) Config {
+	c := DefaultConfig()
+	c.Text = cfg.Caption
+	c.FontFile, c.Font, c.Fallback = cfg.FontFile, cfg.Font, cfg.Fallback
+	c.MissingGlyph, c.Normalize = cfg.MissingGlyph, cfg.Normalize
+	c.DPI, c.Hinting, c.NoAntialias = cfg.DPI, cfg.Hinting, cfg.NoAntialias
+	c.Size = cfg.CaptionSize
+	if c.Size <= 0 {
+		c.Size = cfg.Size * captionScale
+	}
+	c.Proportional, c.Transparent, c.AutoGrow = true, true, true
+	c.Height, c.VAlign = int(math.Ceil(c.Size*c.DPI/72*1.25)), "center"
+	c.Padding, c.MaxPixels, c.Strict = 0, cfg.MaxPixels, cfg.Strict
+	c.FG = fmt.Sprintf("#%02x%02x%02x%02x", drawn.R, drawn.G, drawn.B, drawn.A)
+	return c
+}
+
+// captionColor returns the color of the caption of cfg: CaptionColor if
+// set, else a gray halfway in lightness between the text and the
+// background.
+func captionColor(cfg Config, fg, bg color.RGBA) (color.RGBA, error) {
+	if cfg.CaptionColor != "" {
+		c, err := parseHexColor(cfg.CaptionColor)
+		if err != nil {
+			return c, fmt.Errorf("invalid caption color: %w", err)
+		}
+		return c, nil
+	}
+	lum := func(c color.RGBA) int { return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000 }
+	y := uint8((lum(fg) + lum(bg)) / 2)
+	return color.RGBA{y, y, y, 0xff}, nil
+}
+
+// captionSize returns the size of the caption of cfg.
+func captionSize(cfg Config) (image.Point, error) {
+	size, err := Measure(captionConfig(cfg, color.RGBA{}))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("caption: %w", err)
+	}
+	return size, nil
+}
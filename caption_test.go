@@ -0,0 +1,24 @@
+package txt2png
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestCaptionRejectsSizeNotHeight(t *testing.T) {
+	for name, set := range map[string]func(*Config){
+		"size":         func(c *Config) { c.Size = 0 },
+		"negative dpi": func(c *Config) { c.DPI = -72 },
+	} {
+		cfg := testConfig("text")
+		cfg.Caption = "hi"
+		set(&cfg)
+		if err := captionConfig(cfg, color.RGBA{}).Validate(); err == nil || strings.Contains(err.Error(), "height") {
+			t.Errorf("%s: caption settings give %v, want an error about the size or DPI", name, err)
+		}
+		if _, err := Render(cfg); err == nil || strings.Contains(err.Error(), "height") {
+			t.Errorf("%s: Render returned %v, want an error about the size or DPI", name, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"txt2png"
+)
+
+// atlasEntry is where one label of an atlas ended up, as written to the
+// -map file.
+type atlasEntry struct {
+	Text     string `json:"text"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	W        int    `json:"w"`
+	H        int    `json:"h"`
+	Baseline int    `json:"baseline"` // from the top of the label, -1 if rotated
+}
+
+// atlasMap is the -map file: the size of the atlas and its labels in the
+// order of the list file.
+type atlasMap struct {
+	Image  string       `json:"image"`
+	Width  int          `json:"width"`
+	Height int          `json:"height"`
+	Labels []atlasEntry `json:"labels"`
+}
+
+// runAtlas renders every non-empty line of listFile with the settings of
+// base, packs the images into one saved to outPath and writes where each
+// went to mapPath as JSON.
+func runAtlas(base txt2png.Config, listFile, outPath, mapPath string, opts saveOptions) error {
+	lines, err := readList(listFile)
+	if err != nil {
+		return fmt.Errorf("reading atlas file: %w", err)
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("atlas file %s has no labels", listFile)
+	}
+
+	imgs := make([]*image.RGBA, len(lines))
+	m := atlasMap{Image: filepath.Base(outPath), Labels: make([]atlasEntry, len(lines))}
+	for i, line := range lines {
+		cfg := base
+		cfg.Text = line
+		res, err := txt2png.Render(cfg)
+		if err != nil {
+			return fmt.Errorf("label %q: %w", line, err)
+		}
+		imgs[i] = res.Image
+		b := res.Image.Bounds()
+		m.Labels[i] = atlasEntry{Text: line, W: b.Dx(), H: b.Dy(), Baseline: res.Baseline}
+	}
+	m.Width, m.Height = packShelves(m.Labels)
+
+	atlas := image.NewRGBA(image.Rect(0, 0, m.Width, m.Height))
+	if !base.Transparent {
+		draw.Draw(atlas, atlas.Bounds(), image.NewUniform(opts.BG), image.Point{}, draw.Src)
+	}
+	for i, e := range m.Labels {
+		draw.Draw(atlas, image.Rect(e.X, e.Y, e.X+e.W, e.Y+e.H), imgs[i], image.Point{}, draw.Src)
+	}
+	opts.Text = withTitle(opts.Text, strings.TrimSuffix(filepath.Base(listFile), filepath.Ext(listFile)))
+	if err := saveImage(outPath, atlas, opts); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(mapPath, append(b, '\n'), 0o644); err != nil {
+		return withCode(exitWrite, fmt.Errorf("writing atlas map: %w", err))
+	}
+	return nil
+}
+
+// packShelves places the labels in rows ("shelves"), tallest first, each
+// shelf as tall as its first label, and sets their X and Y. The atlas is
+// as wide as the widest label or the side of a square of the same total
+// area, whichever is more. It returns the size of the atlas.
+func packShelves(labels []atlasEntry) (width, height int) {
+	area := 0
+	for _, e := range labels {
+		area += e.W * e.H
+		width = maxInt(width, e.W)
+	}
+	width = maxInt(width, int(math.Ceil(math.Sqrt(float64(area)))))
+
+	order := make([]int, len(labels))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return labels[order[a]].H > labels[order[b]].H })
+	x, y, shelf := 0, 0, 0
+	for _, i := range order {
+		e := &labels[i]
+		if x > 0 && x+e.W > width {
+			x, y, shelf = 0, y+shelf, 0
+		}
+		e.X, e.Y = x, y
+		x += e.W
+		shelf = maxInt(shelf, e.H)
+	}
+	return width, y + shelf
+}
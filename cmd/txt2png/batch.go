@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"txt2png"
+)
+
+// batchJob is one line of a -batch list file.
+type batchJob struct {
+	text string
+	path string
+}
+
+// runBatch renders every non-empty line of listFile to its own file in
+// outDir, using base for all other settings. The files get the extension
+// ext. Renders run concurrently on GOMAXPROCS workers; failures are logged
+// and counted, and an error is returned if any render failed. With
+// base.Verbose a progress line is printed now and then.
+func runBatch(base txt2png.Config, listFile, outDir, ext string, opts saveOptions) error {
+	lines, err := readList(listFile)
+	if err != nil {
+		return fmt.Errorf("reading batch file: %w", err)
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return withCode(exitWrite, fmt.Errorf("creating output directory: %w", err))
+	}
+
+	var jobs []batchJob
+	used := make(map[string]bool)
+	for _, line := range lines {
+		name := batchName(line, len(jobs)+1, used)
+		jobs = append(jobs, batchJob{line, filepath.Join(outDir, name+ext)})
+	}
+
+	ch := make(chan batchJob)
+	var mu sync.Mutex
+	done, failed := 0, 0
+	progress := func(int, int) {}
+	if base.Verbose {
+		progress = progressLine("images")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				err := renderFile(base, job, opts)
+				if err != nil {
+					log.Printf("Error: %s: %v", job.path, err)
+				} else if base.Verbose {
+					fmt.Fprintf(os.Stderr, "Successfully wrote %s\n", job.path)
+				}
+				mu.Lock()
+				done++
+				if err != nil {
+					failed++
+				}
+				progress(done, len(jobs))
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		ch <- job
+	}
+	close(ch)
+	wg.Wait()
+
+	fmt.Fprintf(os.Stderr, "%d succeeded, %d failed\n", len(jobs)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d renders failed", failed, len(jobs))
+	}
+	return nil
+}
+
+// readList returns the non-empty lines of the file path, without line
+// endings.
+func readList(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// renderFile renders job.text with the settings in cfg and saves it with
+// the encoder settings of opts.
+func renderFile(cfg txt2png.Config, job batchJob, opts saveOptions) error {
+	cfg.Text = job.text
+	res, err := txt2png.Render(cfg)
+	if err != nil {
+		return err
+	}
+	fg, bg, ruler, err := outputColors(cfg)
+	if err != nil {
+		return err
+	}
+	opts.FG, opts.BG, opts.Ruler = fg, bg, ruler
+	opts.Text = withTitle(opts.Text, job.text)
+	return saveImage(job.path, res.Image, opts)
+}
+
+// batchName turns text into a file name made of letters, digits, '-' and
+// '_'. Text that sanitizes to nothing is named by its index, and a name
+// already in used gets the index appended.
+func batchName(text string, index int, used map[string]bool) string {
+	var sb strings.Builder
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			sb.WriteRune(r)
+		case unicode.IsSpace(r) && sb.Len() > 0:
+			sb.WriteByte('_')
+		}
+	}
+	name := strings.Trim(sb.String(), "_")
+	if len(name) > 64 {
+		name = name[:64]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+	switch {
+	case name == "":
+		name = fmt.Sprintf("%d", index)
+	case used[name]:
+		name = fmt.Sprintf("%s_%d", name, index)
+	}
+	used[name] = true
+	return name
+}
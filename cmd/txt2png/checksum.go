@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// hashWriter returns a writer that passes everything on to w and, if on is
+// set, also feeds it to the returned SHA-256 hash, which is nil otherwise.
+func hashWriter(w io.Writer, on bool) (io.Writer, hash.Hash) {
+	if !on {
+		return w, nil
+	}
+	h := sha256.New()
+	return io.MultiWriter(w, h), h
+}
+
+// writeChecksum writes the sum in h of the file at path to path.sha256, in
+// the format of sha256sum so that "sha256sum -c" can check it. It does
+// nothing if h is nil.
+func writeChecksum(path string, h hash.Hash) error {
+	if h == nil {
+		return nil
+	}
+	line := fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.Base(path))
+	if err := os.WriteFile(path+".sha256", []byte(line), 0o644); err != nil {
+		return withCode(exitWrite, fmt.Errorf("writing checksum: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	"txt2png"
+)
+
+// configFields maps each flag that has a txt2png.Config counterpart to the
+// name of that field. Flags missing here, such as -out, exist only on the
+// command line.
+var configFields = map[string]string{
+	"text":            "Text",
+	"textfile":        "Text",
+	"markup":          "Markup",
+	"caption":         "Caption",
+	"captionsize":     "CaptionSize",
+	"captioncolor":    "CaptionColor",
+	"allowempty":      "AllowEmpty",
+	"normalize":       "Normalize",
+	"fontfile":        "FontFile",
+	"font":            "Font",
+	"fallback":        "Fallback",
+	"missingglyph":    "MissingGlyph",
+	"dpi":             "DPI",
+	"hinting":         "Hinting",
+	"noaa":            "NoAntialias",
+	"bold":            "Bold",
+	"boldstrength":    "BoldStrength",
+	"italic":          "Italic",
+	"slant":           "Slant",
+	"size":            "Size",
+	"pixels":          "Pixels",
+	"fitwidth":        "FitWidth",
+	"fitheight":       "FitHeight",
+	"capheight":       "CapHeight",
+	"whiteonblack":    "WhiteOnBlack",
+	"theme":           "Theme",
+	"fg":              "FG",
+	"fggradient":      "FGGradient",
+	"colors":          "CharColors",
+	"bg":              "BG",
+	"bggradient":      "BGGradient",
+	"bggradientdir":   "BGGradientDir",
+	"bgimage":         "BGImage",
+	"bgfit":           "BGFit",
+	"watermark":       "Watermark",
+	"watermarkalpha":  "WatermarkAlpha",
+	"bgalpha":         "BGAlpha",
+	"transparent":     "Transparent",
+	"slotcolors":      "SlotColors",
+	"slotwidth":       "SlotWidth",
+	"widecells":       "WideCells",
+	"totalwidth":      "TotalWidth",
+	"justify":         "Justify",
+	"height":          "Height",
+	"width":           "Width",
+	"anchor":          "Anchor",
+	"repeat":          "Repeat",
+	"offset":          "RepeatOffset",
+	"autogrow":        "AutoGrow",
+	"halign":          "HAlign",
+	"align":           "Align",
+	"valign":          "VAlign",
+	"baseline":        "Baseline",
+	"vertical":        "Vertical",
+	"proportional":    "Proportional",
+	"nokern":          "NoKern",
+	"tabularnums":     "TabularNums",
+	"subpixel":        "Subpixel",
+	"rtl":             "RTL",
+	"padding":         "Padding",
+	"padtop":          "PadTop",
+	"padbottom":       "PadBottom",
+	"padleft":         "PadLeft",
+	"padright":        "PadRight",
+	"tabwidth":        "TabWidth",
+	"linenumbers":     "LineNumbers",
+	"startline":       "StartLine",
+	"tracking":        "Tracking",
+	"wrap":            "Wrap",
+	"linespacing":     "LineSpacing",
+	"guidelines":      "Guidelines",
+	"baselineguide":   "BaselineGuide",
+	"guidelinecolor":  "GuidelineColor",
+	"guidelineevery":  "GuidelineEvery",
+	"guidelinewidth":  "GuidelineWidth",
+	"guidelinesontop": "GuidesOnTop",
+	"ruler":           "Ruler",
+	"rulerstep":       "RulerStep",
+	"rulerlabels":     "RulerLabels",
+	"underline":       "Underline",
+	"strikethrough":   "Strikethrough",
+	"shadow":          "Shadow",
+	"shadowdx":        "ShadowDX",
+	"shadowdy":        "ShadowDY",
+	"glow":            "Glow",
+	"glowradius":      "GlowRadius",
+	"glowcolor":       "GlowColor",
+	"outline":         "Outline",
+	"outlinewidth":    "OutlineWidth",
+	"outlinecolor":    "OutlineColor",
+	"trim":            "Trim",
+	"border":          "Border",
+	"bordercolor":     "BorderColor",
+	"radius":          "Radius",
+	"invert":          "Invert",
+	"typewriter":      "Typewriter",
+	"rotate":          "Rotate",
+	"scale":           "Scale",
+	"maxpixels":       "MaxPixels",
+	"strict":          "Strict",
+	"verbose":         "Verbose",
+}
+
+// loadConfig reads a JSON file holding a txt2png.Config; keys are the
+// field names, matched without regard to case. Fields the file leaves out
+// keep their defaults.
+func loadConfig(path string) (txt2png.Config, error) {
+	cfg := txt2png.DefaultConfig()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("reading config file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// overrideConfig returns base with every field whose flag was given on the
+// command line replaced by its value in flags.
+func overrideConfig(base, flags txt2png.Config) txt2png.Config {
+	dst, src := reflect.ValueOf(&base).Elem(), reflect.ValueOf(flags)
+	flag.Visit(func(f *flag.Flag) {
+		if name, ok := configFields[f.Name]; ok {
+			dst.FieldByName(name).Set(src.FieldByName(name))
+		}
+	})
+	return base
+}
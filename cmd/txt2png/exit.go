@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"txt2png"
+)
+
+// Exit codes of the command, documented in the README so that scripts can
+// tell failures apart.
+const (
+	exitFailure = 1 // any failure not listed below, such as markup errors in the text
+	exitUsage   = 2 // flags that cannot be parsed or combined, or settings the library rejects; the flag package exits with 2 too
+	exitFont    = 3 // a font could not be found, read or parsed
+	exitMissing = 4 // -validate, or a render with -strict, found characters that the fonts lack
+	exitEncode  = 5 // the image could not be encoded
+	exitWrite   = 6 // an output file could not be created or written
+)
+
+// exitError is an error that main reports with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// withCode returns err to be reported with exit code code, or nil if err
+// is nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code, err}
+}
+
+// usageErrorf formats an error about the flags given, reported with
+// exitUsage.
+func usageErrorf(format string, a ...interface{}) error {
+	return &exitError{exitUsage, fmt.Errorf(format, a...)}
+}
+
+// warn logs a warning, or with strict returns it as an error reported
+// with exitUsage, since it is about settings that cannot all be honored.
+func warn(strict bool, format string, a ...interface{}) error {
+	if strict {
+		return usageErrorf("-strict: "+format, a...)
+	}
+	log.Printf("Warning: "+format, a...)
+	return nil
+}
+
+// exitCode returns the exit code that err is reported with.
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	var fe *txt2png.FontError
+	if errors.As(err, &fe) {
+		return exitFont
+	}
+	var ce *txt2png.ConfigError
+	if errors.As(err, &ce) {
+		return exitUsage
+	}
+	var me *txt2png.MissingGlyphError
+	if errors.As(err, &me) {
+		return exitMissing
+	}
+	return exitFailure
+}
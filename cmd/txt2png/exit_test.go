@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the command itself instead of the tests when runCommand
+// starts the test binary again.
+func TestMain(m *testing.M) {
+	if os.Getenv("TXT2PNG_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCommand runs txt2png with args in a new temporary directory and
+// returns its exit code and what it printed on stderr.
+func runCommand(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "TXT2PNG_RUN_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var ee *exec.ExitError
+	if err != nil && !errors.As(err, &ee) {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.ExitCode(), stderr.String()
+}
+
+func TestBadSizes(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		msg  string
+	}{
+		{[]string{"-slotwidth", "0"}, "slot width must be at least 1 pixel, got 0"},
+		{[]string{"-slotwidth", "-5"}, "slot width must be at least 1 pixel, got -5"},
+		{[]string{"-height", "0"}, "height must be at least 1 pixel, got 0"},
+		{[]string{"-height", "-40"}, "height must be at least 1 pixel, got -40"},
+		{[]string{"-tabwidth", "0"}, "tab width must be at least 1, got 0"},
+		{[]string{"-size", "0"}, "font size must be positive, got 0"},
+		{[]string{"-size", "-50"}, "font size must be positive, got -50"},
+		{[]string{"-dpi", "0"}, "DPI must be positive, got 0"},
+		{[]string{"-dpi", "-72"}, "DPI must be positive, got -72"},
+		{[]string{"-caption", "hi", "-size", "0"}, "font size must be positive, got 0"},
+		{[]string{"-caption", "hi", "-dpi", "0"}, "DPI must be positive, got 0"},
+		// Checked before the missing font is looked for.
+		{[]string{"-height", "0", "-fontfile", "missing.ttf"}, "height must be at least 1 pixel"},
+	} {
+		code, stderr := runCommand(t, tc.args...)
+		if code != exitUsage {
+			t.Errorf("%v: exit code %d, want %d", tc.args, code, exitUsage)
+		}
+		if !strings.Contains(stderr, tc.msg) || strings.Contains(stderr, "panic") {
+			t.Errorf("%v: stderr %q, want a message containing %q", tc.args, stderr, tc.msg)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	for _, tc := range []struct {
+		code int
+		args []string
+	}{
+		{exitFailure, []string{"-markup", "-text", "{f:"}},
+		{exitFailure, []string{"-text", ""}},
+		{exitUsage, []string{"-nosuchflag"}},
+		{exitUsage, []string{"-mono", "-grayscale"}},
+		{exitUsage, []string{"-out", "out.webp"}},
+		{exitUsage, []string{"-hinting", "ful"}},
+		{exitUsage, []string{"-valign", "x"}},
+		{exitUsage, []string{"-halign", "x"}},
+		{exitUsage, []string{"-align", "x"}},
+		{exitUsage, []string{"-theme", "x"}},
+		{exitUsage, []string{"-fg", "red"}},
+		{exitUsage, []string{"-rotate", "45"}},
+		{exitUsage, []string{"-padding", "-1"}},
+		{exitUsage, []string{"-linespacing", "0"}},
+		{exitUsage, []string{"-size", "20000"}},
+		{exitUsage, []string{"-capheight", "100000"}},
+		{exitUsage, []string{"-strict", "-transparent", "-out", "out.jpg"}},
+		{exitFont, []string{"-fontfile", "missing.ttf"}},
+		{exitFont, []string{"-fallback", "missing.ttf"}},
+		{exitMissing, []string{"-validate", "-text", "日本"}},
+		{exitMissing, []string{"-strict", "-autogrow", "-text", "日本"}},
+		// GIF images are at most 65535 pixels wide.
+		{exitEncode, []string{"-slotwidth", "70000", "-height", "8", "-size", "6", "-text", "A", "-out", "out.gif"}},
+		{exitWrite, []string{"-out", "missing/out.png"}},
+	} {
+		if code, stderr := runCommand(t, tc.args...); code != tc.code {
+			t.Errorf("%v: exit code %d, want %d (%s)", tc.args, code, tc.code, strings.TrimSpace(stderr))
+		}
+	}
+}
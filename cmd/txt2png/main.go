@@ -0,0 +1,883 @@
+// txt2png
+// Convert text to PNG image
+//
+// Usage:
+// txt2png -text "JOJO" -fontfile ./LiberationMono-Regular.ttf -dpi 72 -hinting none -size 125 -whiteonblack -out out.png -slotwidth 250 -height 200
+//
+// Original code: https://github.com/chrplr/txt2png
+//
+// License: GPL-3.0
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"txt2png"
+)
+
+var def = txt2png.DefaultConfig()
+
+var (
+	configFile     = flag.String("config", "", "JSON file of settings (keys are txt2png.Config fields); flags given on the command line override it")
+	dpi            = flag.Float64("dpi", def.DPI, "screen resolution in Dots Per Inch")
+	fontfile       = flag.String("fontfile", def.FontFile, "filename of the TrueType (.ttf) or OpenType (.otf) font")
+	fontName       = flag.String("font", "", "name of an installed font, e.g. \"DejaVu Sans Mono\" (overrides -fontfile)")
+	fallback       = flag.String("fallback", "", "comma-separated font files used for characters the main font lacks")
+	missingGlyph   = flag.String("missingglyph", def.MissingGlyph, "characters no font has: skip | box | question")
+	hinting        = flag.String("hinting", def.Hinting, "none | vertical | full")
+	noAA           = flag.Bool("noaa", false, "turn antialiasing off and draw glyphs with hard edges")
+	bold           = flag.Bool("bold", false, "faux bold: thicken the strokes of a regular font by drawing each glyph several times")
+	boldStrength   = flag.Int("boldstrength", def.BoldStrength, "extra stroke width of -bold in pixels")
+	italic         = flag.Bool("italic", false, "faux italic: slant the glyphs of a regular font")
+	slant          = flag.Float64("slant", def.Slant, "slant of -italic in degrees; negative leans left")
+	fontSize       = flag.Float64("size", def.Size, "font size in points")
+	pixels         = flag.Bool("pixels", false, "treat -size as pixels rather than points (ignores -dpi)")
+	fitWidth       = flag.Int("fitwidth", 0, "choose the font size so the text fits an image this many pixels wide (overrides -size)")
+	fitHeight      = flag.Int("fitheight", 0, "choose the font size so the text fits an image this many pixels tall (overrides -size and -height)")
+	capHeight      = flag.Int("capheight", 0, "choose the font size so capital letters are exactly this many pixels tall (overrides -size)")
+	wonb           = flag.Bool("whiteonblack", false, "white text on a black background")
+	themeName      = flag.String("theme", "", "color preset: "+strings.Join(txt2png.ThemeNames(), " | ")+" (-fg and -bg override it)")
+	fgColor        = flag.String("fg", "", "text color as #rgb, #rrggbb or #rrggbbaa (overrides -whiteonblack)")
+	fgGradient     = flag.String("fggradient", "", "fill the text top to bottom with a gradient, \"#from -> #to\" (overrides -fg)")
+	charColors     = flag.String("colors", "", "comma-separated text colors cycled across the characters, e.g. \"#f00,#0f0,#00f\" (overrides -fg)")
+	bgColor        = flag.String("bg", "", "background color as #rgb, #rrggbb or #rrggbbaa (overrides -whiteonblack)")
+	text           = flag.String("text", def.Text, "text to render (\"-\" reads it from standard input)")
+	escape         = flag.Bool("escape", false, "interpret Go escapes such as \\n, \\t and \\u00e9 in -text")
+	markup         = flag.Bool("markup", false, "interpret markup in the text: font switches {f:bold.ttf}Hello{/f}, subscripts H_{2}O and superscripts x^{2} ({{ is a literal {)")
+	caption        = flag.String("caption", "", "smaller text centered below the main text")
+	captionSize    = flag.Float64("captionsize", 0, "caption font size, in the units of -size (default 40% of the text size)")
+	captionColor   = flag.String("captioncolor", "", "caption color, as -fg (default a gray between the text and background colors)")
+	normalizeForm  = flag.String("normalize", def.Normalize, "Unicode normalization of the text: NFC | NFD | none")
+	allowEmpty     = flag.Bool("allowempty", false, "render empty text as a blank single-slot image instead of failing")
+	textFile       = flag.String("textfile", "", "file containing the text to render (cannot be combined with -text)")
+	outFile        = flag.String("out", "out.png", "output filename; the extension selects the format (.png, .jpg, .jpeg, .gif, .bmp); \"-\" writes PNG to stdout")
+	quality        = flag.Int("quality", 90, "JPEG quality, 1-100")
+	grayscaleOut   = flag.Bool("grayscale", false, "write PNG, JPEG and BMP output as 8-bit grayscale")
+	force          = flag.Bool("force", false, "with -grayscale, convert even an image with colors or transparency")
+	mono           = flag.Bool("mono", false, "write 1-bit black and white output (PNG, GIF or BMP), e.g. for thermal printers")
+	threshold      = flag.Int("threshold", 128, "with -mono, pixels darker than this luminance (0-255) are black")
+	dither         = flag.Bool("dither", false, "with -mono, Floyd–Steinberg dither instead of a plain threshold")
+	checksum       = flag.Bool("checksum", false, "also write the SHA-256 of each output file to <out>.sha256")
+	pngLevelName   = flag.String("pnglevel", "default", "PNG compression: default | none | speed | best")
+	slotWidth      = flag.Int("slotwidth", def.SlotWidth, "width of each character slot in pixels")
+	wideCells      = flag.Bool("widecells", false, "give East Asian wide and fullwidth characters two slots each")
+	totalWidth     = flag.Int("totalwidth", 0, "make the slots together exactly this many pixels wide (overrides -slotwidth)")
+	justify        = flag.Bool("justify", false, "spread each line over -totalwidth with equal gaps, first character at the left edge and last at the right")
+	vertical       = flag.Bool("vertical", false, "stack characters top to bottom in -height tall cells; each line is a column, right to left")
+	proportional   = flag.Bool("proportional", false, "lay glyphs out by their advance widths instead of in fixed slots")
+	noKern         = flag.Bool("nokern", false, "do not apply the font's kerning in -proportional mode")
+	tabularNums    = flag.Bool("tabularnums", false, "with -proportional, give all digits the width of the widest so numbers line up")
+	subpixel       = flag.Bool("subpixel", false, "with -proportional, keep fractional glyph positions instead of rounding each advance to whole pixels")
+	rtl            = flag.Bool("rtl", false, "right-to-left text: the first character is on the right (no shaping)")
+	padding        = flag.Int("padding", 0, "blank pixels around the text on every side")
+	padTop         = flag.Int("padtop", def.PadTop, "top padding in pixels (default -padding)")
+	padBottom      = flag.Int("padbottom", def.PadBottom, "bottom padding in pixels (default -padding)")
+	padLeft        = flag.Int("padleft", def.PadLeft, "left padding in pixels (default -padding)")
+	padRight       = flag.Int("padright", def.PadRight, "right padding in pixels (default -padding)")
+	tabWidth       = flag.Int("tabwidth", def.TabWidth, "tab stops every N slots (every N space widths with -proportional)")
+	lineNumbers    = flag.Bool("linenumbers", false, "number the lines in a gutter on the left, dimmer than the text")
+	startLine      = flag.Int("startline", def.StartLine, "number of the first line with -linenumbers")
+	tracking       = flag.Int("tracking", 0, "extra pixels between letters, may be negative (in slot mode this changes the slot width)")
+	imageHeight    = flag.Int("height", def.Height, "height of the image in pixels")
+	imageWidth     = flag.Int("width", 0, "make the image exactly this wide and -height tall, with the text placed at -anchor")
+	anchor         = flag.String("anchor", def.Anchor, "where -width places the text: nw | n | ne | w | center | e | sw | s | se")
+	repeat         = flag.Bool("repeat", false, "tile the text over the whole -width x -height image (rows as tall as the font's line)")
+	repeatOffset   = flag.Int("offset", 0, "with -repeat, shift each row of tiles this many pixels right of the one above")
+	bgGradient     = flag.String("bggradient", "", "background gradient as \"#from -> #to\", interpolated in RGB (overrides -bg)")
+	bgGradientDir  = flag.String("bggradientdir", def.BGGradientDir, "direction of -bggradient: h (left to right) | v (top to bottom) | diag")
+	bgImage        = flag.String("bgimage", "", "PNG, JPEG or GIF image drawn as the background")
+	bgFit          = flag.String("bgfit", def.BGFit, "how -bgimage fills the image: stretch | tile | center")
+	watermark      = flag.String("watermark", "", "text drawn faintly across the image behind the main text, e.g. \"DRAFT\"")
+	watermarkAlpha = flag.Float64("watermarkalpha", def.WatermarkAlpha, "opacity of -watermark, from 0 to 1")
+	autoGrow       = flag.Bool("autogrow", false, "make the image taller when glyphs would be clipped at the top or bottom")
+	bgAlpha        = flag.Int("bgalpha", def.BGAlpha, "opacity of the background, 0 (clear) to 255 (opaque); the text stays opaque")
+	transparent    = flag.Bool("transparent", false, "transparent background (ignored for JPEG output)")
+	slotColors     = flag.String("slotcolors", "", "comma-separated index:color pairs tinting the background of those slots, counted from 0, e.g. \"0:#fdd,3:#dfd\"")
+	hAlign         = flag.String("halign", def.HAlign, "alignment of glyphs within their slots: left | center | right")
+	align          = flag.String("align", "", "alignment of each line of multi-line text: comma-separated l | c | r, the last one repeating, e.g. \"c,l\" centers the first line and left-aligns the others")
+	vAlign         = flag.String("valign", def.VAlign, "vertical alignment: top | center | bottom | baseline | legacy")
+	baselineY      = flag.Int("baseline", 0, "put the first baseline exactly this many pixels from the top, overriding -valign")
+	showGuidelines = flag.Bool("guidelines", false, "draw vertical guidelines between character slots")
+	baselineGuide  = flag.Bool("baselineguide", false, "draw a horizontal guideline at the text baseline")
+	guideColor     = flag.String("guidelinecolor", "", "guideline color as #rgb, #rrggbb or #rrggbbaa (default a shade of the background)")
+	guideEvery     = flag.Int("guidelineevery", def.GuidelineEvery, "draw a guideline every N slots")
+	guideWidth     = flag.Int("guidelinewidth", def.GuidelineWidth, "guideline width in pixels")
+	guidesOnTop    = flag.Bool("guidelinesontop", false, "draw the guidelines half transparent over the text instead of under it, as a measurement overlay")
+	ruler          = flag.Bool("ruler", false, "draw a pixel ruler with numbered ticks along the top and left edges")
+	rulerStep      = flag.Int("rulerstep", def.RulerStep, "pixels between -ruler ticks")
+	rulerLabels    = flag.Int("rulerlabels", def.RulerLabels, "number every N -ruler ticks")
+	underline      = flag.Bool("underline", false, "underline each line of text")
+	strikethrough  = flag.Bool("strikethrough", false, "strike through each line of text")
+	shadow         = flag.Bool("shadow", false, "draw a semi-transparent drop shadow behind the text")
+	shadowDX       = flag.Int("shadowdx", def.ShadowDX, "horizontal shadow offset in pixels")
+	shadowDY       = flag.Int("shadowdy", def.ShadowDY, "vertical shadow offset in pixels")
+	glow           = flag.Bool("glow", false, "draw a soft blurred halo behind the text, for a neon look")
+	glowRadius     = flag.Int("glowradius", def.GlowRadius, "how far -glow spreads, in pixels")
+	glowColor      = flag.String("glowcolor", "", "glow color as #rgb, #rrggbb or #rrggbbaa (default -fg)")
+	outline        = flag.Bool("outline", false, "draw a contrasting outline around each glyph")
+	outlineWidth   = flag.Int("outlinewidth", def.OutlineWidth, "outline width in pixels")
+	outlineColor   = flag.String("outlinecolor", "", "outline color as #rgb, #rrggbb or #rrggbbaa (default black or white, contrasting with -fg)")
+	wrap           = flag.Int("wrap", 0, "wrap text at spaces so that no line is wider than this many pixels (0 disables)")
+	lineSpacing    = flag.Float64("linespacing", def.LineSpacing, "line height as a multiple of the font's natural line height")
+	trim           = flag.Bool("trim", false, "crop the image to the ink, keeping -padding around it")
+	border         = flag.Int("border", 0, "draw a frame this many pixels wide inside the edges of the image")
+	borderColor    = flag.String("bordercolor", "", "frame color as #rgb, #rrggbb or #rrggbbaa (default the text color)")
+	radius         = flag.Int("radius", 0, "round the corners of the image to this radius in pixels, leaving them transparent")
+	invert         = flag.Bool("invert", false, "turn the image into its negative, e.g. black on white into white on black")
+	typewriter     = flag.Bool("typewriter", false, "write an animated GIF revealing one character per frame (needs a .gif -out)")
+	frameDelay     = flag.Int("framedelay", 10, "delay between -typewriter frames in hundredths of a second")
+	loopCount      = flag.Int("loop", 0, "times a -typewriter animation repeats: 0 loops forever, -1 plays once")
+	rotation       = flag.Int("rotate", 0, "rotate the image clockwise by 0, 90, 180 or 270 degrees")
+	glyphSheet     = flag.Bool("glyphsheet", false, "render every printable character of -range in a grid with its code point under it, instead of -text")
+	runeRange      = flag.String("range", "U+0020-U+007E", "characters of -glyphsheet, as U+XXXX-U+YYYY")
+	batchFile      = flag.String("batch", "", "render each line of this file to its own image in -outdir")
+	atlasFile      = flag.String("atlas", "", "render each line of this file and pack them all into the -out image, described by -map")
+	mapFile        = flag.String("map", "", "JSON file listing where -atlas put each label (default -out with a .json extension)")
+	inputFile      = flag.String("input", "", "post-process this existing image instead of rendering text: apply -invert and save it as -out")
+	outDir         = flag.String("outdir", ".", "output directory for -batch; the extension of -out selects the format")
+	scaleFactor    = flag.Int("scale", 1, "enlarge the image by this integer factor with nearest-neighbor scaling, e.g. 2 for @2x assets")
+	measure        = flag.Bool("measure", false, "print the size of the image as WIDTHxHEIGHT (as JSON with -json) and exit without writing it; ignores -trim")
+	validate       = flag.Bool("validate", false, "check that the fonts have every character of the text (or of -charset), list the missing ones and exit, failing if any are missing")
+	charset        = flag.String("charset", "", "file whose characters -validate checks instead of the text")
+	preview        = flag.Bool("preview", false, "also print a coarse ASCII preview of the image on stdout, as wide as $COLUMNS")
+	jsonOut        = flag.Bool("json", false, "print the output path, image size and font size as JSON on stdout (disables -verbose)")
+	maxPixels      = flag.Int("maxpixels", def.MaxPixels, "refuse to render images of more than this many pixels; 0 for no limit")
+	strict         = flag.Bool("strict", false, "fail instead of warning, e.g. on missing glyphs, clipped text or settings the output format cannot store")
+	verbose        = flag.Bool("verbose", false, "print informational messages to the console")
+)
+
+// version is the tool version recorded in PNG metadata; release builds
+// set it with -ldflags "-X main.version=...".
+var version = "dev"
+
+// metadataKV holds the -metadata flags, in order.
+var metadataKV stringList
+
+func init() {
+	flag.Var(&metadataKV, "metadata", "key=value text stored in PNG output; may be repeated (Title and Software are set by default)")
+}
+
+// stringList is a flag.Value collecting every use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// run does the actual work so that main is the only place deciding how a
+// failure is reported and which exit code it gets.
+func run() error {
+	var txt string
+	if *batchFile == "" {
+		var err error
+		txt, err = readText(*text, *textFile)
+		if err != nil {
+			return err
+		}
+		if *escape && *textFile == "" && *text != "-" {
+			if txt, err = unescape(txt); err != nil {
+				return err
+			}
+		}
+	}
+
+	cfg := txt2png.Config{
+		Text:           txt,
+		Markup:         *markup,
+		Caption:        *caption,
+		CaptionSize:    *captionSize,
+		CaptionColor:   *captionColor,
+		AllowEmpty:     *allowEmpty,
+		Normalize:      *normalizeForm,
+		FontFile:       *fontfile,
+		Font:           *fontName,
+		Fallback:       splitList(*fallback),
+		MissingGlyph:   *missingGlyph,
+		DPI:            *dpi,
+		Hinting:        *hinting,
+		NoAntialias:    *noAA,
+		Bold:           *bold,
+		BoldStrength:   *boldStrength,
+		Italic:         *italic,
+		Slant:          *slant,
+		Size:           *fontSize,
+		Pixels:         *pixels,
+		FitWidth:       *fitWidth,
+		FitHeight:      *fitHeight,
+		CapHeight:      *capHeight,
+		WhiteOnBlack:   *wonb,
+		Theme:          *themeName,
+		FG:             *fgColor,
+		FGGradient:     *fgGradient,
+		CharColors:     splitList(*charColors),
+		BG:             *bgColor,
+		BGGradient:     *bgGradient,
+		BGGradientDir:  *bgGradientDir,
+		BGImage:        *bgImage,
+		BGFit:          *bgFit,
+		Watermark:      *watermark,
+		WatermarkAlpha: *watermarkAlpha,
+		BGAlpha:        *bgAlpha,
+		Transparent:    *transparent,
+		SlotColors:     splitList(*slotColors),
+		SlotWidth:      *slotWidth,
+		WideCells:      *wideCells,
+		TotalWidth:     *totalWidth,
+		Justify:        *justify,
+		Height:         *imageHeight,
+		Width:          *imageWidth,
+		Anchor:         *anchor,
+		Repeat:         *repeat,
+		RepeatOffset:   *repeatOffset,
+		AutoGrow:       *autoGrow,
+		HAlign:         *hAlign,
+		Align:          *align,
+		VAlign:         *vAlign,
+		Baseline:       *baselineY,
+		Vertical:       *vertical,
+		Proportional:   *proportional,
+		NoKern:         *noKern,
+		TabularNums:    *tabularNums,
+		Subpixel:       *subpixel,
+		RTL:            *rtl,
+		Padding:        *padding,
+		PadTop:         *padTop,
+		PadBottom:      *padBottom,
+		PadLeft:        *padLeft,
+		PadRight:       *padRight,
+		TabWidth:       *tabWidth,
+		LineNumbers:    *lineNumbers,
+		StartLine:      *startLine,
+		Tracking:       *tracking,
+		Wrap:           *wrap,
+		LineSpacing:    *lineSpacing,
+		Guidelines:     *showGuidelines,
+		BaselineGuide:  *baselineGuide,
+		GuidelineColor: *guideColor,
+		GuidelineEvery: *guideEvery,
+		GuidelineWidth: *guideWidth,
+		GuidesOnTop:    *guidesOnTop,
+		Ruler:          *ruler,
+		RulerStep:      *rulerStep,
+		RulerLabels:    *rulerLabels,
+		Underline:      *underline,
+		Strikethrough:  *strikethrough,
+		Shadow:         *shadow,
+		ShadowDX:       *shadowDX,
+		ShadowDY:       *shadowDY,
+		Glow:           *glow,
+		GlowRadius:     *glowRadius,
+		GlowColor:      *glowColor,
+		Outline:        *outline,
+		OutlineWidth:   *outlineWidth,
+		OutlineColor:   *outlineColor,
+		Trim:           *trim,
+		Border:         *border,
+		BorderColor:    *borderColor,
+		Radius:         *radius,
+		Invert:         *invert,
+		Typewriter:     *typewriter,
+		Rotate:         *rotation,
+		Scale:          *scaleFactor,
+		MaxPixels:      *maxPixels,
+		Strict:         *strict,
+		Verbose:        *verbose,
+	}
+	if *configFile != "" {
+		base, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		cfg = overrideConfig(base, cfg)
+	}
+	// Settings that cannot work fail before any font is loaded.
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if *validate {
+		cs := *charset
+		if cs == "" {
+			// A batch has no -text; its list file holds the characters.
+			cs = *batchFile
+		}
+		return validateFonts(cfg, cs)
+	}
+	if *measure {
+		if *batchFile != "" {
+			return usageErrorf("-measure cannot be used with -batch")
+		}
+		return printMeasure(cfg, *jsonOut)
+	}
+
+	format, err := imageFormat(*outFile)
+	if err != nil {
+		return err
+	}
+	if *jsonOut && *outFile == "-" {
+		return usageErrorf("-json cannot be used with -out -")
+	}
+	if *batchFile != "" && *outFile == "-" {
+		return usageErrorf("-batch writes its images to -outdir and cannot be used with -out -")
+	}
+	if *preview && (*outFile == "-" || *jsonOut) {
+		return usageErrorf("-preview cannot be used with -out - or -json, which also write to stdout")
+	}
+	if *glyphSheet && (cfg.Typewriter || *batchFile != "") {
+		return usageErrorf("-glyphsheet cannot be used with -typewriter or -batch")
+	}
+	if cfg.Typewriter && *batchFile != "" {
+		return usageErrorf("-typewriter cannot be used with -batch")
+	}
+	if *atlasFile != "" && (cfg.Typewriter || *batchFile != "" || *glyphSheet || *preview || *jsonOut) {
+		return usageErrorf("-atlas cannot be used with -typewriter, -batch, -glyphsheet, -preview or -json")
+	}
+	if *inputFile != "" && (cfg.Typewriter || *batchFile != "" || *atlasFile != "" || *glyphSheet || *jsonOut) {
+		return usageErrorf("-input cannot be used with -typewriter, -batch, -atlas, -glyphsheet or -json")
+	}
+	if *atlasFile != "" && *outFile == "-" && *mapFile == "" {
+		return usageErrorf("-atlas with -out - needs a -map file")
+	}
+	if cfg.Typewriter && format != "gif" {
+		return usageErrorf("-typewriter needs GIF output, got %s", *outFile)
+	}
+	if cfg.Transparent && format == "jpeg" {
+		if err := warn(cfg.Strict, "JPEG cannot store transparency, ignoring -transparent"); err != nil {
+			return err
+		}
+		cfg.Transparent = false
+	}
+	if cfg.Radius > 0 && format == "jpeg" {
+		if err := warn(cfg.Strict, "JPEG cannot store transparency, the corners cut by -radius will show the background color"); err != nil {
+			return err
+		}
+	}
+	if cfg.BGAlpha < 0xff && (format == "jpeg" || format == "gif") {
+		if err := warn(cfg.Strict, "%s cannot store a translucent background, ignoring -bgalpha", strings.ToUpper(format)); err != nil {
+			return err
+		}
+		cfg.BGAlpha = 0xff
+	}
+
+	if *checksum && *outFile == "-" && *batchFile == "" {
+		return usageErrorf("-checksum needs an output file, not -out -")
+	}
+	if *mono && *grayscaleOut {
+		return usageErrorf("-mono and -grayscale cannot be used together")
+	}
+	if *mono && cfg.Typewriter {
+		return usageErrorf("-mono cannot be used with -typewriter")
+	}
+	if *threshold < 0 || *threshold > 255 {
+		return usageErrorf("threshold must be between 0 and 255, got %d", *threshold)
+	}
+	level, err := pngLevel(*pngLevelName)
+	if err != nil {
+		return err
+	}
+	meta, err := metadata(cfg.Text, metadataKV)
+	if err != nil {
+		return err
+	}
+
+	opts := saveOptions{
+		Quality:   *quality,
+		PNGLevel:  level,
+		Text:      meta,
+		Grayscale: *grayscaleOut,
+		Force:     *force,
+		Mono:      *mono,
+		Threshold: *threshold,
+		Dither:    *dither,
+		Checksum:  *checksum,
+		Strict:    cfg.Strict,
+	}
+	if *inputFile != "" {
+		if opts.FG, opts.BG, opts.Ruler, err = outputColors(cfg); err != nil {
+			return err
+		}
+		return runInput(*inputFile, *outFile, cfg.Invert, opts)
+	}
+	if *batchFile != "" {
+		return runBatch(cfg, *batchFile, *outDir, filepath.Ext(*outFile), opts)
+	}
+	if *atlasFile != "" {
+		mapPath := *mapFile
+		if mapPath == "" {
+			mapPath = strings.TrimSuffix(*outFile, filepath.Ext(*outFile)) + ".json"
+		}
+		if opts.FG, opts.BG, opts.Ruler, err = outputColors(cfg); err != nil {
+			return err
+		}
+		return runAtlas(cfg, *atlasFile, *outFile, mapPath, opts)
+	}
+
+	if *jsonOut {
+		cfg.Verbose = false
+	}
+	if cfg.Verbose && *glyphSheet {
+		cfg.Progress = progressLine("glyphs")
+	} else if cfg.Verbose && cfg.Typewriter {
+		cfg.Progress = progressLine("frames")
+	}
+
+	var res *txt2png.Result
+	if *glyphSheet {
+		from, to, err := parseRange(*runeRange)
+		if err != nil {
+			return err
+		}
+		sheet, err := txt2png.GlyphSheet(cfg, from, to, sheetColumns)
+		if err != nil {
+			return err
+		}
+		res = &txt2png.Result{Image: sheet, Size: cfg.Size}
+	} else if res, err = txt2png.Render(cfg); err != nil {
+		return err
+	}
+	rgba := res.Image
+
+	if opts.FG, opts.BG, opts.Ruler, err = outputColors(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Typewriter {
+		err = saveAnimation(*outFile, res.Frames, *frameDelay, *loopCount, opts)
+	} else {
+		err = saveImage(*outFile, rgba, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *preview {
+		var bg color.Color = opts.BG
+		if cfg.Transparent {
+			bg = color.Transparent
+		}
+		if err := printPreview(os.Stdout, rgba, bg, terminalColumns()); err != nil {
+			return err
+		}
+	}
+	if *jsonOut {
+		return printJSON(*outFile, res)
+	}
+	if cfg.Verbose {
+		if fi, err := os.Stat(*outFile); err == nil && *outFile != "-" {
+			fmt.Fprintf(os.Stderr, "Successfully wrote %s (%d bytes)\n", *outFile, fi.Size())
+		} else {
+			fmt.Fprintf(os.Stderr, "Successfully wrote %s\n", *outFile)
+		}
+	}
+	return nil
+}
+
+// outputColors returns the colors of the image rendered for cfg as it is
+// saved, which -invert turns into their complements.
+func outputColors(cfg txt2png.Config) (fg, bg, ruler color.RGBA, err error) {
+	if fg, bg, ruler, err = cfg.Colors(); err != nil || !cfg.Invert {
+		return fg, bg, ruler, err
+	}
+	return txt2png.Negative(fg), txt2png.Negative(bg), txt2png.Negative(ruler), nil
+}
+
+// runInput loads the image in the file path instead of rendering one,
+// inverts it if invert is set and saves it to outPath.
+func runInput(path, outPath string, invert bool, opts saveOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening input image: %w", err)
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("decoding input image %s: %w", path, err)
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if invert {
+		txt2png.Invert(rgba)
+	}
+	opts.Text = withTitle(opts.Text, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	return saveImage(outPath, rgba, opts)
+}
+
+// sheetColumns is the number of cells in a row of a -glyphsheet.
+const sheetColumns = 16
+
+// parseRange parses a character range written as U+XXXX-U+YYYY. The U+
+// prefixes are optional.
+func parseRange(s string) (from, to rune, err error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, usageErrorf("range %q is not of the form U+XXXX-U+YYYY", s)
+	}
+	parse := func(v string) (rune, error) {
+		v = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(v)), "U+")
+		n, err := strconv.ParseUint(v, 16, 32)
+		if err != nil || n > unicode.MaxRune {
+			return 0, usageErrorf("range %q: %q is not a code point", s, v)
+		}
+		return rune(n), nil
+	}
+	if from, err = parse(lo); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parse(hi); err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
+
+// printJSON writes a machine-readable summary of the render to stdout.
+func printJSON(path string, res *txt2png.Result) error {
+	b := res.Image.Bounds()
+	return json.NewEncoder(os.Stdout).Encode(struct {
+		Out    string  `json:"out"`
+		Width  int     `json:"width"`
+		Height int     `json:"height"`
+		Size   float64 `json:"size"`
+	}{path, b.Dx(), b.Dy(), res.Size})
+}
+
+// validateFonts prints the characters of cfg.Text, or of the file charset
+// if it is set, that the fonts of cfg lack, and fails if there are any.
+func validateFonts(cfg txt2png.Config, charset string) error {
+	chars := cfg.Text
+	if charset != "" {
+		b, err := os.ReadFile(charset)
+		if err != nil {
+			return fmt.Errorf("reading charset: %w", err)
+		}
+		chars = string(b)
+	}
+	missing, err := txt2png.MissingGlyphs(cfg, chars)
+	if err != nil {
+		return err
+	}
+	for _, r := range missing {
+		fmt.Printf("U+%04X %q\n", r, r)
+	}
+	if len(missing) > 0 {
+		return withCode(exitMissing, fmt.Errorf("the fonts lack %d characters", len(missing)))
+	}
+	if cfg.Verbose {
+		fmt.Fprintf(os.Stderr, "All characters are covered\n")
+	}
+	return nil
+}
+
+// printMeasure prints the size of the image cfg renders to, as JSON if
+// asJSON is set.
+func printMeasure(cfg txt2png.Config, asJSON bool) error {
+	if asJSON {
+		cfg.Verbose = false
+	}
+	size, err := txt2png.Measure(cfg)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		}{size.X, size.Y})
+	}
+	fmt.Printf("%dx%d\n", size.X, size.Y)
+	return nil
+}
+
+// readText returns the text to render. If path is set the text is read from
+// that file; otherwise the value "-" means the text is read from standard
+// input. Trailing newlines are stripped in both cases, so an input of only
+// newlines counts as empty text.
+func readText(arg, path string) (string, error) {
+	var b []byte
+	var err error
+	switch {
+	case path != "":
+		if isFlagSet("text") {
+			return "", usageErrorf("-text and -textfile cannot be used together")
+		}
+		b, err = os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading text file: %w", err)
+		}
+	case arg == "-":
+		b, err = io.ReadAll(bufio.NewReader(os.Stdin))
+		if err != nil {
+			return "", fmt.Errorf("reading text from stdin: %w", err)
+		}
+	default:
+		return arg, nil
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// unescape interprets the Go escape sequences in s, such as \n, \t,
+// \u00e9 and \U0001F600.
+func unescape(s string) (string, error) {
+	var buf []byte
+	orig := s
+	for len(s) > 0 {
+		if len(s) > 1 && s[0] == '\\' && (s[1] == '"' || s[1] == '\'') {
+			// UnquoteChar only takes the escape of the quote it is given.
+			buf, s = append(buf, s[1]), s[2:]
+			continue
+		}
+		r, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in text %q", orig)
+		}
+		if r < utf8.RuneSelf || !multibyte {
+			buf = append(buf, byte(r))
+		} else {
+			buf = utf8.AppendRune(buf, r)
+		}
+		s = tail
+	}
+	return string(buf), nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// imageFormat returns the output format implied by the extension of path.
+// Standard output ("-") gets PNG.
+func imageFormat(path string) (string, error) {
+	if path == "-" {
+		return "png", nil
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".png":
+		return "png", nil
+	case ".jpg", ".jpeg":
+		return "jpeg", nil
+	case ".gif":
+		return "gif", nil
+	case ".bmp":
+		return "bmp", nil
+	case ".webp":
+		// golang.org/x/image/webp only decodes, and there is no encoder
+		// without cgo to fall back on; refuse rather than write a PNG
+		// under a .webp name.
+		return "", usageErrorf("WebP output is not supported by this build: no pure-Go WebP encoder is available (write a .png and convert it with cwebp)")
+	default:
+		return "", usageErrorf("unsupported output format %q (supported: .png, .jpg, .jpeg, .gif, .bmp)", ext)
+	}
+}
+
+// pngLevel returns the PNG compression level called name.
+func pngLevel(name string) (png.CompressionLevel, error) {
+	switch name {
+	case "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "speed":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	}
+	return 0, usageErrorf("invalid PNG compression level %q (want default, none, speed or best)", name)
+}
+
+// saveOptions carries the encoder settings and the colors the encoders
+// need to preserve.
+type saveOptions struct {
+	Quality       int                  // JPEG quality, 1-100
+	PNGLevel      png.CompressionLevel // PNG compression level
+	Text          []textChunk          // PNG text chunks
+	Grayscale     bool                 // write PNG and JPEG as 8-bit grayscale
+	Force         bool                 // convert to grayscale even if colors or transparency are lost
+	Mono          bool                 // write PNG, GIF and BMP as 1-bit black and white
+	Threshold     int                  // luminance, 0-255, below which Mono pixels are black
+	Dither        bool                 // Floyd–Steinberg dither Mono output
+	Checksum      bool                 // also write the SHA-256 of the file to <path>.sha256
+	Strict        bool                 // fail instead of warning
+	FG, BG, Ruler color.RGBA
+}
+
+// saveImage encodes rgba in the format selected by the extension of path,
+// writing it to standard output if path is "-".
+// JPEG has no alpha channel, so for JPEG output any transparency is
+// flattened onto an opaque version of the background color. GIF output is
+// reduced to a 256-color palette that always contains the text, background
+// and guideline colors.
+func saveImage(path string, rgba *image.RGBA, opts saveOptions) error {
+	format, err := imageFormat(path)
+	if err != nil {
+		return err
+	}
+	if format == "jpeg" && (opts.Quality < 1 || opts.Quality > 100) {
+		return usageErrorf("JPEG quality must be between 1 and 100, got %d", opts.Quality)
+	}
+	if opts.Mono && format == "jpeg" {
+		return usageErrorf("-mono needs PNG, GIF or BMP output, got %s", path)
+	}
+
+	var img image.Image = rgba
+	switch {
+	case opts.Mono:
+		img = monochrome(rgba, opts)
+	case opts.Grayscale && format != "gif":
+		if img, err = grayscale(rgba, opts); err != nil {
+			return err
+		}
+	}
+
+	// The image is encoded in memory first so that encoding and writing
+	// fail with their own exit codes.
+	var buf bytes.Buffer
+	if err := txt2png.Encode(&buf, img, format, opts.encodeOptions()); err != nil {
+		return withCode(exitEncode, err)
+	}
+	data := buf.Bytes()
+	if format == "png" {
+		data = insertText(data, opts.Text)
+	}
+	return writeOutput(path, data, opts.Checksum)
+}
+
+// saveAnimation writes frames as an animated GIF to path, showing each one
+// for delay hundredths of a second and repeating loop times (0 forever, -1
+// once).
+func saveAnimation(path string, frames []*image.RGBA, delay, loop int, opts saveOptions) error {
+	if delay < 0 {
+		return usageErrorf("frame delay must not be negative, got %d", delay)
+	}
+	eo := opts.encodeOptions()
+	eo.Delay, eo.Loop = delay, loop
+	var buf bytes.Buffer
+	if err := txt2png.EncodeAnimation(&buf, frames, eo); err != nil {
+		return withCode(exitEncode, err)
+	}
+	return writeOutput(path, buf.Bytes(), opts.Checksum)
+}
+
+// encodeOptions returns the settings of opts that the encoders take. GIF
+// palettes keep the text, background and guideline colors exact.
+func (opts saveOptions) encodeOptions() *txt2png.EncodeOptions {
+	return &txt2png.EncodeOptions{
+		Quality:  opts.Quality,
+		PNGLevel: opts.PNGLevel,
+		BG:       opts.BG,
+		Keep:     []color.RGBA{opts.BG, opts.FG, opts.Ruler},
+	}
+}
+
+// writeOutput writes data to the file path, or to standard output if path
+// is "-", along with its checksum file if checksum is set.
+func writeOutput(path string, data []byte, checksum bool) error {
+	out, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, sum := hashWriter(out, checksum)
+	if _, err := w.Write(data); err != nil {
+		return withCode(exitWrite, fmt.Errorf("writing output: %w", err))
+	}
+	if err := out.Close(); err != nil {
+		return withCode(exitWrite, fmt.Errorf("writing output: %w", err))
+	}
+	return writeChecksum(path, sum)
+}
+
+// createOutput creates the file path, or returns standard output if path
+// is "-".
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, withCode(exitWrite, fmt.Errorf("creating output file: %w", err))
+	}
+	return out, nil
+}
+
+// nopCloser keeps standard output open when the output is closed.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// grayscale returns img as an 8-bit grayscale image. If img has colored or
+// translucent pixels, which would be lost, it warns and returns img
+// unchanged unless opts.Force is set, or fails with opts.Strict;
+// translucent pixels are then flattened onto the background first.
+func grayscale(img *image.RGBA, opts saveOptions) (image.Image, error) {
+	if !isGray(img) {
+		if !opts.Force {
+			return img, warn(opts.Strict, "image has colors or transparency, ignoring -grayscale (use -force to convert anyway)")
+		}
+		img = txt2png.Flatten(img, opts.BG)
+	}
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray, nil
+}
+
+// isGray reports whether every pixel of img is opaque and has equal red,
+// green and blue.
+func isGray(img *image.RGBA) bool {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A != 0xff || c.R != c.G || c.G != c.B {
+				return false
+			}
+		}
+	}
+	return true
+}
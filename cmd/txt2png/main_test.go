@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/bmp"
+
+	"txt2png"
+)
+
+// testRender renders text small in the embedded font and returns the
+// image with save options carrying its colors.
+func testRender(t *testing.T, text string) (*image.RGBA, saveOptions) {
+	t.Helper()
+	cfg := txt2png.DefaultConfig()
+	cfg.Text, cfg.FontFile = text, ""
+	cfg.Size, cfg.SlotWidth, cfg.Height = 40, 40, 60
+	cfg.Guidelines = true
+	img, err := txt2png.RenderText(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := saveOptions{Quality: 90}
+	if opts.FG, opts.BG, opts.Ruler, err = cfg.Colors(); err != nil {
+		t.Fatal(err)
+	}
+	return img, opts
+}
+
+func TestSaveGIF(t *testing.T) {
+	img, opts := testRender(t, "GIF")
+	path := filepath.Join(t.TempDir(), "out.gif")
+	if err := saveImage(path, img, opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := gif.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dec.Bounds().Size(), img.Bounds().Size(); got != want {
+		t.Fatalf("GIF is %v, want %v", got, want)
+	}
+	// Two-color text has fewer than 256 colors, so the palette is exact.
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if got, want := color.RGBAModel.Convert(dec.At(x, y)), img.RGBAAt(x, y); got != want {
+				t.Fatalf("pixel %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestSaveBMP(t *testing.T) {
+	// An odd width makes the rows need padding.
+	img, opts := testRender(t, "BMP")
+	img = img.SubImage(image.Rect(0, 0, 117, 59)).(*image.RGBA)
+	path := filepath.Join(t.TempDir(), "out.bmp")
+	if err := saveImage(path, img, opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := bmp.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dec.Bounds().Size(), img.Bounds().Size(); got != want {
+		t.Fatalf("BMP is %v, want %v", got, want)
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if got, want := color.RGBAModel.Convert(dec.At(x, y)), img.RGBAAt(x, y); got != want {
+				t.Fatalf("pixel %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// renderAndSave renders text as run does and saves it to a new file in
+// dir named name, returning the bytes written.
+func renderAndSave(t *testing.T, dir, name, text string, img *image.RGBA) []byte {
+	t.Helper()
+	src, opts := testRender(t, text)
+	if img != nil {
+		src = img
+	}
+	var err error
+	if opts.Text, err = metadata(text, []string{"Author=txt2png tests", "Comment=twice"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := saveImage(path, src, opts); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOutputIsDeterministic(t *testing.T) {
+	// More colors than a GIF palette holds, each as frequent as the
+	// others, so that the palette depends on how ties are broken.
+	many := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	for i := 0; i < 30*20; i++ {
+		many.SetRGBA(i%30, i/30, color.RGBA{uint8(i), uint8(i >> 8), 0x80, 0xff})
+	}
+	for _, ext := range []string{".png", ".gif", ".jpg", ".bmp"} {
+		for _, img := range []*image.RGBA{nil, many} {
+			dir := t.TempDir()
+			first := renderAndSave(t, dir, "a"+ext, "Same text", img)
+			for i := 0; i < 3; i++ {
+				if again := renderAndSave(t, dir, "b"+ext, "Same text", img); !bytes.Equal(again, first) {
+					t.Fatalf("%s output differs between runs", ext)
+				}
+			}
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for in, want := range map[string]string{
+		`a\tb\nc`:         "a\tb\nc",
+		`say \"hi\"`:      `say "hi"`,
+		`it\'s`:           "it's",
+		`café \U0001F600`: "café 😀",
+		`back\\slash`:     `back\slash`,
+		`"quoted"`:        `"quoted"`,
+	} {
+		got, err := unescape(in)
+		if err != nil {
+			t.Errorf("unescape(%q): %v", in, err)
+		} else if got != want {
+			t.Errorf("unescape(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if _, err := unescape(`bad \q`); err == nil {
+		t.Error(`unescape("bad \q") succeeded, want an error`)
+	}
+}
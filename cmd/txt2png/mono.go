@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"txt2png"
+)
+
+// monoPalette is the palette of 1-bit output: ink, then paper.
+var monoPalette = color.Palette{color.Gray{0}, color.Gray{0xff}}
+
+// monochrome reduces img to black and white for 1-bit output, after
+// flattening any transparency onto the background. A pixel is black if its
+// luminance is below opts.Threshold; with opts.Dither the rounding error is
+// spread over the neighboring pixels (Floyd–Steinberg), so that
+// antialiasing and gradients come out as patterns of dots.
+func monochrome(img *image.RGBA, opts saveOptions) *image.Paletted {
+	img = txt2png.Flatten(img, opts.BG)
+	b := img.Bounds()
+	dst := image.NewPaletted(b, monoPalette)
+	w := b.Dx()
+	// Errors carried to the current and the next row, one slot of margin
+	// on each side.
+	cur, next := make([]float64, w+2), make([]float64, w+2)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := x - b.Min.X + 1
+			lum := float64(color.GrayModel.Convert(img.RGBAAt(x, y)).(color.Gray).Y) + cur[i]
+			out := 0.0
+			if lum >= float64(opts.Threshold) {
+				out = 0xff
+				dst.SetColorIndex(x, y, 1)
+			}
+			if opts.Dither {
+				e := lum - out
+				cur[i+1] += e * 7 / 16
+				next[i-1] += e * 3 / 16
+				next[i] += e * 5 / 16
+				next[i+1] += e * 1 / 16
+			}
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = 0
+		}
+	}
+	return dst
+}
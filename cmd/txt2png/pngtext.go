@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+)
+
+// textChunk is a keyword/value pair stored in a PNG text chunk.
+type textChunk struct {
+	Key, Value string
+}
+
+// metadata returns the text chunks written into PNG output: the rendered
+// text as Title, the tool as Software, then each key=value of extra, which
+// replaces an earlier chunk with the same key. There is deliberately no
+// creation time, so that rendering the same text twice gives the same
+// bytes.
+func metadata(text string, extra []string) ([]textChunk, error) {
+	chunks := []textChunk{{"Title", text}, {"Software", "txt2png " + version}}
+	for _, kv := range extra {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, usageErrorf("metadata %q is not of the form key=value", kv)
+		}
+		if err := checkKeyword(k); err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i := range chunks {
+			if chunks[i].Key == k {
+				chunks[i].Value, replaced = v, true
+			}
+		}
+		if !replaced {
+			chunks = append(chunks, textChunk{k, v})
+		}
+	}
+	return chunks, nil
+}
+
+// withTitle returns chunks with the Title set to text.
+func withTitle(chunks []textChunk, text string) []textChunk {
+	out := append([]textChunk(nil), chunks...)
+	for i := range out {
+		if out[i].Key == "Title" {
+			out[i].Value = text
+		}
+	}
+	return out
+}
+
+// checkKeyword reports whether k is a valid PNG keyword: 1 to 79 printable
+// Latin-1 characters without leading, trailing or consecutive spaces.
+func checkKeyword(k string) error {
+	if len(k) == 0 || len(k) > 79 || strings.HasPrefix(k, " ") || strings.HasSuffix(k, " ") || strings.Contains(k, "  ") {
+		return usageErrorf("invalid metadata key %q", k)
+	}
+	for _, r := range k {
+		if r < 0x20 || (r > 0x7e && r < 0xa1) || r > 0xff {
+			return usageErrorf("invalid metadata key %q", k)
+		}
+	}
+	return nil
+}
+
+// insertText returns the PNG file data with the chunks inserted after its
+// IHDR chunk. Values that are not Latin-1 go into iTXt chunks, which hold
+// UTF-8; the rest go into tEXt chunks.
+func insertText(data []byte, chunks []textChunk) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // signature, then IHDR length, type, data and CRC
+	var buf bytes.Buffer
+	buf.Write(data[:ihdrEnd])
+	for _, c := range chunks {
+		if latin1, ok := toLatin1(c.Value); ok {
+			writeChunk(&buf, "tEXt", append(append(toLatin1Key(c.Key), 0), latin1...))
+		} else {
+			// Uncompressed, with empty language tag and translated keyword.
+			body := append(toLatin1Key(c.Key), 0, 0, 0, 0, 0)
+			writeChunk(&buf, "iTXt", append(body, c.Value...))
+		}
+	}
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes()
+}
+
+func writeChunk(buf *bytes.Buffer, typ string, body []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(body)))
+	buf.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(body)
+	buf.WriteString(typ)
+	buf.Write(body)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	buf.Write(n[:])
+}
+
+// toLatin1 converts s to Latin-1, reporting false if it has other
+// characters.
+func toLatin1(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+// toLatin1Key converts a keyword accepted by checkKeyword to Latin-1.
+func toLatin1Key(k string) []byte {
+	b, _ := toLatin1(k)
+	return b
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+)
+
+// previewRamp lists the characters of a preview from no ink to full ink.
+const previewRamp = " .:-=+*#%@"
+
+// terminalColumns returns the width of the terminal from $COLUMNS, or 80
+// if it is unset or invalid.
+func terminalColumns() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// printPreview writes a coarse ASCII rendering of img to w, at most cols
+// characters wide. Each character stands for a block of pixels twice as
+// tall as it is wide, to make up for the shape of terminal cells, and is
+// darker the more the block differs from bg.
+func printPreview(w io.Writer, img image.Image, bg color.Color, cols int) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil
+	}
+	cw := maxInt((b.Dx()+cols-1)/maxInt(cols, 1), 1)
+	ch := 2 * cw
+	lum := func(c color.Color) float64 {
+		return float64(color.Gray16Model.Convert(c).(color.Gray16).Y) / 0xffff
+	}
+	bgLum := lum(bg)
+	_, _, _, bgA := bg.RGBA()
+	bw := bufio.NewWriter(w)
+	for y := b.Min.Y; y < b.Max.Y; y += ch {
+		for x := b.Min.X; x < b.Max.X; x += cw {
+			ink, n := 0.0, 0
+			for yy := y; yy < y+ch && yy < b.Max.Y; yy++ {
+				for xx := x; xx < x+cw && xx < b.Max.X; xx++ {
+					c := img.At(xx, yy)
+					_, _, _, a := c.RGBA()
+					d := lum(c) - bgLum
+					if bgA == 0 {
+						// On a transparent background the ink is the alpha.
+						d = float64(a) / 0xffff
+					}
+					if d < 0 {
+						d = -d
+					}
+					ink += d
+					n++
+				}
+			}
+			i := int(ink/float64(n)*float64(len(previewRamp)-1) + 0.5)
+			bw.WriteByte(previewRamp[i])
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
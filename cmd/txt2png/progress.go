@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progressEvery is the least time between two progress lines.
+const progressEvery = time.Second
+
+// progressLine returns a txt2png.Config.Progress callback that prints
+// "rendered done/total what" to standard error, at most once every
+// progressEvery and always once the job is complete. It is not safe for
+// concurrent use.
+func progressLine(what string) func(done, total int) {
+	var last time.Time
+	return func(done, total int) {
+		if now := time.Now(); done == total || now.Sub(last) >= progressEvery {
+			last = now
+			fmt.Fprintf(os.Stderr, "rendered %d/%d %s\n", done, total, what)
+		}
+	}
+}
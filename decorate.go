@@ -0,0 +1,139 @@
+package txt2png
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// underlineMetrics returns the distance from the baseline down to the top
+// of the underline and the underline thickness, in pixels, for a font
+// rendered at pxPerEm pixels per em. The values come from the font's 'post'
+// table when it has one; otherwise they are derived from the font size.
+func underlineMetrics(f *parsedFont, pxPerEm float64) (pos, thickness int) {
+	if f.sfnt != nil {
+		if post := f.sfnt.PostTable(); post != nil && post.UnderlineThickness > 0 {
+			scale := pxPerEm / float64(f.sfnt.UnitsPerEm())
+			pos = int(math.Round(-float64(post.UnderlinePosition) * scale))
+			thickness = int(math.Round(float64(post.UnderlineThickness) * scale))
+			return pos, maxInt(thickness, 1)
+		}
+	}
+	return int(math.Round(pxPerEm / 10)), maxInt(int(math.Round(pxPerEm/16)), 1)
+}
+
+// xHeight returns the x-height in pixels, from the font's OS/2 table when
+// available, else from the top of the 'x' glyph, else as half an em.
+func xHeight(f *parsedFont, face font.Face, pxPerEm float64) int {
+	if f.sfnt != nil {
+		var buf sfnt.Buffer
+		ppem := fixed.Int26_6(math.Round(pxPerEm * 64))
+		if m, err := f.sfnt.Metrics(&buf, ppem, font.HintingNone); err == nil && m.XHeight > 0 {
+			return m.XHeight.Round()
+		}
+	}
+	if b, _, ok := face.GlyphBounds('x'); ok && b.Min.Y < 0 {
+		return (-b.Min.Y).Round()
+	}
+	return int(math.Round(pxPerEm / 2))
+}
+
+// drawRules draws a horizontal rule under every line of tl. The top of the
+// rule is offset pixels below the line's baseline (negative values are
+// above it) and it is thickness pixels tall.
+func drawRules(dst draw.Image, tl textLayout, offset, thickness int, src image.Image) {
+	for row, w := range tl.widths {
+		if w == 0 {
+			continue
+		}
+		o := tl.origins[row]
+		y := o.Y + offset
+		r := image.Rect(o.X, y, o.X+w, y+thickness)
+		draw.Draw(dst, r, src, image.Point{}, draw.Over)
+	}
+}
+
+// drawMissingBoxes draws a hollow box, sized like a capital letter of face,
+// in place of every glyph of tl marked as a missing-glyph placeholder.
+func drawMissingBoxes(dst draw.Image, tl textLayout, face font.Face, src image.Image) {
+	h := face.Metrics().Ascent.Round() * 7 / 10
+	stroke := maxInt(h/12, 1)
+	for row, glyphs := range tl.lines {
+		for _, g := range glyphs {
+			if !g.box {
+				continue
+			}
+			p := tl.glyphPt(row, g, image.Point{})
+			x, y := p.X.Round(), p.Y.Round()
+			margin := g.advance / 8
+			outer := image.Rect(x+margin, y-h, x+g.advance-margin, y)
+			inner := outer.Inset(stroke)
+			for _, r := range []image.Rectangle{
+				{outer.Min, image.Pt(outer.Max.X, inner.Min.Y)},
+				{image.Pt(outer.Min.X, inner.Max.Y), outer.Max},
+				{image.Pt(outer.Min.X, inner.Min.Y), image.Pt(inner.Min.X, inner.Max.Y)},
+				{image.Pt(inner.Max.X, inner.Min.Y), image.Pt(outer.Max.X, inner.Max.Y)},
+			} {
+				draw.Draw(dst, r, src, image.Point{}, draw.Over)
+			}
+		}
+	}
+}
+
+// drawBorder paints a frame width pixels wide in c along the inside of the
+// edges of dst, over whatever is there.
+func drawBorder(dst *image.RGBA, width int, c color.RGBA) {
+	if width <= 0 {
+		return
+	}
+	b := dst.Bounds()
+	inner := b.Inset(width)
+	src := image.NewUniform(c)
+	for _, r := range []image.Rectangle{
+		{b.Min, image.Pt(b.Max.X, inner.Min.Y)},
+		{image.Pt(b.Min.X, inner.Max.Y), b.Max},
+		{image.Pt(b.Min.X, inner.Min.Y), image.Pt(inner.Min.X, inner.Max.Y)},
+		{image.Pt(inner.Max.X, inner.Min.Y), image.Pt(b.Max.X, inner.Max.Y)},
+	} {
+		draw.Draw(dst, r.Intersect(b), src, image.Point{}, draw.Src)
+	}
+}
+
+// roundCorners clears the corners of dst outside a rounded rectangle with
+// corners of the given radius, fading the pixels the curve crosses by how
+// much of them lies inside it. The radius is at most half the shorter side.
+func roundCorners(dst *image.RGBA, radius int) {
+	b := dst.Bounds()
+	radius = minInt(radius, minInt(b.Dx(), b.Dy())/2)
+	if radius <= 0 {
+		return
+	}
+	r := float64(radius)
+	for dy := 0; dy < radius; dy++ {
+		for dx := 0; dx < radius; dx++ {
+			// Distance from the center of the corner's circle to the
+			// center of the pixel, which is dx, dy from the corner.
+			dist := math.Hypot(r-float64(dx)-0.5, r-float64(dy)-0.5)
+			cover := math.Max(0, math.Min(1, r-dist+0.5))
+			if cover == 1 {
+				continue
+			}
+			for _, p := range []image.Point{
+				{b.Min.X + dx, b.Min.Y + dy},
+				{b.Max.X - 1 - dx, b.Min.Y + dy},
+				{b.Min.X + dx, b.Max.Y - 1 - dy},
+				{b.Max.X - 1 - dx, b.Max.Y - 1 - dy},
+			} {
+				px := dst.Pix[dst.PixOffset(p.X, p.Y):][:4]
+				for i := range px {
+					px[i] = uint8(math.Round(float64(px[i]) * cover))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package txt2png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBorderPixels(t *testing.T) {
+	const w = 3
+	bg, frame := color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0xc0, 0x10, 0x10, 0xff}
+	img := createImage(20, 12, image.NewUniform(bg))
+	drawBorder(img, w, frame)
+	inner := img.Bounds().Inset(w)
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 20; x++ {
+			want := frame
+			if image.Pt(x, y).In(inner) {
+				want = bg
+			}
+			if got := img.RGBAAt(x, y); got != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestBorderDoesNotShiftText(t *testing.T) {
+	cfg := testConfig("AB")
+	cfg.Padding = 6
+	plain, err := RenderText(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Border, cfg.BorderColor = 4, "#00f"
+	framed, err := RenderText(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if framed.Bounds() != plain.Bounds() {
+		t.Fatalf("border changed the size from %v to %v", plain.Bounds(), framed.Bounds())
+	}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	inner := framed.Bounds().Inset(cfg.Border)
+	for y := 0; y < framed.Bounds().Dy(); y++ {
+		for x := 0; x < framed.Bounds().Dx(); x++ {
+			want := plain.RGBAAt(x, y)
+			if !image.Pt(x, y).In(inner) {
+				want = blue
+			}
+			if got := framed.RGBAAt(x, y); got != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
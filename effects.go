@@ -0,0 +1,247 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// shadowColor is the color of the drop shadow.
+var shadowColor = color.NRGBA{0x00, 0x00, 0x00, 0x80}
+
+// drawGlyphs draws every glyph of tl in src, shifted by d. Unlike
+// renderText it is silent; it is used for the extra passes behind the text.
+func drawGlyphs(dr *font.Drawer, faces faceChain, tl textLayout, src image.Image, d image.Point) {
+	dr.Src = src
+	for row, glyphs := range tl.lines {
+		for _, g := range glyphs {
+			if g.ok {
+				dr.Face = faces.faces[g.font]
+				dr.Dot = tl.glyphPt(row, g, d)
+				dr.DrawString(string(g.r))
+			}
+		}
+	}
+}
+
+// drawGlow draws the glyphs of tl onto dst in c, blurred so that they
+// spread about radius pixels, as a halo for the text drawn over it.
+func drawGlow(dst *image.RGBA, faces faceChain, tl textLayout, radius int, c color.RGBA) {
+	mask := image.NewAlpha(dst.Bounds())
+	drawGlyphs(newDrawer(mask, image.Opaque, faces.primary()), faces, tl, image.Opaque, image.Point{})
+	blurAlpha(mask, radius)
+	// Blurring thins the ink out; doubling it keeps the halo visible
+	// right next to the strokes.
+	for i, a := range mask.Pix {
+		mask.Pix[i] = uint8(minInt(int(a)*2, 0xff))
+	}
+	draw.DrawMask(dst, dst.Bounds(), image.NewUniform(c), image.Point{}, mask, mask.Bounds().Min, draw.Over)
+}
+
+// blurAlpha blurs m in place with three passes of a box blur, which come
+// close to a Gaussian blur, spreading each pixel up to radius pixels away.
+func blurAlpha(m *image.Alpha, radius int) {
+	r := maxInt((radius+2)/3, 1)
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	buf := make([]uint8, maxInt(w, h))
+	for pass := 0; pass < 3; pass++ {
+		for y := 0; y < h; y++ {
+			boxBlur(m.Pix[y*m.Stride:], 1, w, r, buf)
+		}
+		for x := 0; x < w; x++ {
+			boxBlur(m.Pix[x:], m.Stride, h, r, buf)
+		}
+	}
+}
+
+// boxBlur replaces each of the n values of p, stride apart, by the mean of
+// the values within r of it, counting those past the ends as 0. buf must
+// hold n values.
+func boxBlur(p []uint8, stride, n, r int, buf []uint8) {
+	sum := 0
+	for i := 0; i < minInt(r, n); i++ {
+		sum += int(p[i*stride])
+	}
+	for i := 0; i < n; i++ {
+		if j := i + r; j < n {
+			sum += int(p[j*stride])
+		}
+		if j := i - r - 1; j >= 0 {
+			sum -= int(p[j*stride])
+		}
+		buf[i] = uint8(sum / (2*r + 1))
+	}
+	for i := 0; i < n; i++ {
+		p[i*stride] = buf[i]
+	}
+}
+
+// diskOffsets returns the non-zero offsets within radius r of the origin.
+// Drawing a glyph at each of them produces an outline r pixels wide.
+func diskOffsets(r int) []image.Point {
+	var pts []image.Point
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if (dx != 0 || dy != 0) && dx*dx+dy*dy <= r*r {
+				pts = append(pts, image.Pt(dx, dy))
+			}
+		}
+	}
+	return pts
+}
+
+// contrastingColor returns opaque black for light colors and opaque white
+// for dark ones.
+func contrastingColor(c color.RGBA) color.RGBA {
+	if (299*int(c.R)+587*int(c.G)+114*int(c.B))/1000 >= 0x80 {
+		return color.RGBA{0x00, 0x00, 0x00, 0xff}
+	}
+	return color.RGBA{0xff, 0xff, 0xff, 0xff}
+}
+
+// aliased returns a copy of fc whose faces draw glyphs with hard edges.
+func (fc faceChain) aliased() faceChain {
+	out := faceChain{fontSet: fc.fontSet, em: fc.em}
+	for _, f := range fc.faces {
+		out.faces = append(out.faces, aliasedFace{f})
+	}
+	return out
+}
+
+// aliasedFace is a face whose glyph masks are thresholded to fully on or
+// fully off, turning antialiasing off.
+type aliasedFace struct {
+	font.Face
+}
+
+func (f aliasedFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	dr, mask, maskp, advance, ok := f.Face.Glyph(dot, r)
+	if !ok {
+		return dr, mask, maskp, advance, ok
+	}
+	hard := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	for y := 0; y < dr.Dy(); y++ {
+		for x := 0; x < dr.Dx(); x++ {
+			if _, _, _, a := mask.At(maskp.X+x, maskp.Y+y).RGBA(); a >= 0x8000 {
+				hard.Pix[y*hard.Stride+x] = 0xff
+			}
+		}
+	}
+	return dr, hard, image.Point{}, advance, true
+}
+
+// drawWatermark draws text across dst along its diagonal, in gray at the
+// given opacity (0 to 1). The text is rendered with the fonts of cfg and
+// sized to fill most of the diagonal.
+func drawWatermark(dst *image.RGBA, text string, opacity float64, cfg Config) error {
+	b := dst.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	angle := math.Atan2(h, w)
+
+	wcfg := DefaultConfig()
+	wcfg.Text = text
+	wcfg.FontFile, wcfg.Font, wcfg.Fallback = cfg.FontFile, cfg.Font, cfg.Fallback
+	wcfg.DPI = cfg.DPI
+	wcfg.Proportional = true
+	wcfg.Transparent = true
+	wcfg.FG = "#808080"
+	wcfg.FitWidth = int(0.8 * math.Hypot(w, h))
+	wcfg.FitHeight = maxInt(int(0.5*math.Min(w, h)), 1)
+	wcfg.Height = wcfg.FitHeight
+	wcfg.VAlign = "top"
+	wcfg.Trim = true
+	res, err := Render(wcfg)
+	if err != nil {
+		return fmt.Errorf("watermark: %w", err)
+	}
+	mark := res.Image
+	for i := range mark.Pix {
+		mark.Pix[i] = uint8(float64(mark.Pix[i])*opacity + 0.5)
+	}
+
+	// Rotate about the centers so that the text rises from bottom left to
+	// top right.
+	mb := mark.Bounds()
+	sin, cos := math.Sin(-angle), math.Cos(-angle)
+	cx, cy := float64(mb.Dx())/2, float64(mb.Dy())/2
+	tx, ty := w/2, h/2
+	s2d := f64.Aff3{
+		cos, -sin, tx - cos*cx + sin*cy,
+		sin, cos, ty - sin*cx - cos*cy,
+	}
+	xdraw.BiLinear.Transform(dst, s2d, mark, mb, draw.Over, nil)
+	return nil
+}
+
+// sheared returns a copy of fc whose faces slant glyphs by the given angle
+// in degrees, for a faux italic.
+func (fc faceChain) sheared(slant float64) faceChain {
+	out := faceChain{fontSet: fc.fontSet, em: fc.em}
+	k := math.Tan(slant * math.Pi / 180)
+	for _, f := range fc.faces {
+		out.faces = append(out.faces, shearedFace{f, k})
+	}
+	return out
+}
+
+// shearedFace is a face whose glyphs are shifted right by k pixels for
+// every pixel above the baseline, and left below it.
+type shearedFace struct {
+	font.Face
+	k float64
+}
+
+// shift returns how far the row whose top is y pixels below the baseline
+// (negative above it) moves right.
+func (f shearedFace) shift(y float64) float64 {
+	return -(y + 0.5) * f.k
+}
+
+func (f shearedFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	dr, mask, maskp, advance, ok := f.Face.Glyph(dot, r)
+	if !ok || dr.Empty() {
+		return dr, mask, maskp, advance, ok
+	}
+	base := float64(dot.Y) / 64
+	top, bottom := f.shift(float64(dr.Min.Y)-base), f.shift(float64(dr.Max.Y-1)-base)
+	lo, hi := int(math.Floor(math.Min(top, bottom))), int(math.Ceil(math.Max(top, bottom)))
+	out := image.Rect(dr.Min.X+lo, dr.Min.Y, dr.Max.X+hi, dr.Max.Y)
+	sheared := image.NewAlpha(image.Rect(0, 0, out.Dx(), out.Dy()))
+	at := func(x, y int) float64 {
+		if x < 0 || x >= dr.Dx() {
+			return 0
+		}
+		_, _, _, a := mask.At(maskp.X+x, maskp.Y+y).RGBA()
+		return float64(a)
+	}
+	for y := 0; y < dr.Dy(); y++ {
+		// Each row moves by a fraction of a pixel, so its pixels are
+		// blended with their left neighbours to keep the edges smooth.
+		s := f.shift(float64(dr.Min.Y+y)-base) - float64(lo)
+		i := int(math.Floor(s))
+		frac := s - float64(i)
+		for x := 0; x < out.Dx(); x++ {
+			a := (1-frac)*at(x-i, y) + frac*at(x-i-1, y)
+			sheared.Pix[y*sheared.Stride+x] = uint8(a/0x101 + 0.5)
+		}
+	}
+	return out, sheared, image.Point{}, advance, true
+}
+
+func (f shearedFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	b, advance, ok := f.Face.GlyphBounds(r)
+	if !ok || b.Empty() {
+		return b, advance, ok
+	}
+	top, bottom := f.shift(float64(b.Min.Y)/64), f.shift(float64(b.Max.Y)/64-1)
+	b.Min.X += fixed.Int26_6(math.Floor(math.Min(top, bottom) * 64))
+	b.Max.X += fixed.Int26_6(math.Ceil(math.Max(top, bottom) * 64))
+	return b, advance, ok
+}
@@ -0,0 +1,188 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/bmp"
+)
+
+// EncodeOptions are the encoder settings of Encode and EncodeAnimation.
+// A nil *EncodeOptions selects the defaults.
+type EncodeOptions struct {
+	Quality  int                  // JPEG quality, 1-100; 0 means 90
+	PNGLevel png.CompressionLevel // PNG compression level
+	BG       color.RGBA           // background that JPEG output flattens transparency onto, made opaque
+	Keep     []color.RGBA         // colors that GIF palettes always reproduce exactly, such as the text and background colors
+	Delay    int                  // delay between animation frames in hundredths of a second
+	Loop     int                  // times an animation repeats: 0 loops forever, -1 plays once
+}
+
+// Encode writes img to w in format: "png", "jpeg" (or "jpg"), "gif" or
+// "bmp". JPEG has no alpha channel, so the transparency of an *image.RGBA
+// is flattened onto o.BG, and a GIF of an *image.RGBA gets a palette of
+// its 256 most frequent colors after those of o.Keep. Other image types
+// are passed to the encoders as they are.
+func Encode(w io.Writer, img image.Image, format string, o *EncodeOptions) error {
+	if o == nil {
+		o = &EncodeOptions{}
+	}
+	var err error
+	switch format {
+	case "png":
+		enc := png.Encoder{CompressionLevel: o.PNGLevel}
+		err = enc.Encode(w, img)
+	case "jpeg", "jpg":
+		q := o.Quality
+		if q == 0 {
+			q = 90
+		}
+		if q < 1 || q > 100 {
+			return fmt.Errorf("JPEG quality must be between 1 and 100, got %d", q)
+		}
+		if rgba, ok := img.(*image.RGBA); ok {
+			img = Flatten(rgba, o.BG)
+		}
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: q})
+	case "gif":
+		opts := gif.Options{NumColors: 256}
+		if rgba, ok := img.(*image.RGBA); ok {
+			opts.Quantizer, opts.Drawer = paletteQuantizer(gifPalette(rgba, o.Keep...)), draw.Src
+		}
+		err = gif.Encode(w, img, &opts)
+	case "bmp":
+		err = bmp.Encode(w, img)
+	default:
+		return fmt.Errorf("unsupported image format %q (want png, jpeg, gif or bmp)", format)
+	}
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", strings.ToUpper(format), err)
+	}
+	return nil
+}
+
+// EncodeAnimation writes frames to w as an animated GIF, showing each one
+// for o.Delay hundredths of a second and repeating o.Loop times. All frames
+// share the palette of the last one, which for a Typewriter render has the
+// complete text.
+func EncodeAnimation(w io.Writer, frames []*image.RGBA, o *EncodeOptions) error {
+	if o == nil {
+		o = &EncodeOptions{}
+	}
+	if o.Delay < 0 {
+		return fmt.Errorf("frame delay must not be negative, got %d", o.Delay)
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to write")
+	}
+	pal := gifPalette(frames[len(frames)-1], o.Keep...)
+	anim := gif.GIF{LoopCount: o.Loop}
+	for _, f := range frames {
+		pm := image.NewPaletted(f.Bounds(), pal)
+		draw.Draw(pm, pm.Bounds(), f, f.Bounds().Min, draw.Src)
+		anim.Image = append(anim.Image, pm)
+		anim.Delay = append(anim.Delay, o.Delay)
+	}
+	if err := gif.EncodeAll(w, &anim); err != nil {
+		return fmt.Errorf("encoding GIF: %w", err)
+	}
+	return nil
+}
+
+// RenderTo renders cfg and writes the image to w in format (see Encode),
+// without going through a file, for example to serve it over HTTP. With
+// cfg.Typewriter the format must be "gif" and the whole animation is
+// written, a tenth of a second per frame. Transparency is kept where the
+// format has it.
+func RenderTo(w io.Writer, cfg Config, format string) error {
+	if cfg.Typewriter && format != "gif" {
+		return fmt.Errorf("typewriter animations need the gif format, got %q", format)
+	}
+	res, err := Render(cfg)
+	if err != nil {
+		return err
+	}
+	fg, bg, ruler, err := cfg.Colors()
+	if err != nil {
+		return err
+	}
+	if cfg.Invert {
+		// The colors went through the inversion with the image.
+		fg, bg, ruler = Negative(fg), Negative(bg), Negative(ruler)
+	}
+	o := &EncodeOptions{BG: bg, Keep: []color.RGBA{bg, fg, ruler}, Delay: 10}
+	if cfg.Typewriter {
+		return EncodeAnimation(w, res.Frames, o)
+	}
+	return Encode(w, res.Image, format, o)
+}
+
+// Flatten composites img over an opaque fill of bg.
+func Flatten(img *image.RGBA, bg color.RGBA) *image.RGBA {
+	bg.A = 0xff
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// gifPalette builds a palette of at most 256 colors for img. The colors in
+// keep come first so they are reproduced exactly; the remaining entries are
+// the most frequent colors of the image. Text rendered in two colors rarely
+// needs more than 256, in which case the GIF is lossless.
+func gifPalette(img *image.RGBA, keep ...color.RGBA) color.Palette {
+	counts := make(map[color.RGBA]int)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			counts[img.RGBAAt(x, y)]++
+		}
+	}
+
+	var p color.Palette
+	seen := make(map[color.RGBA]bool)
+	add := func(c color.RGBA) {
+		if !seen[c] && len(p) < 256 {
+			seen[c] = true
+			p = append(p, c)
+		}
+	}
+	for _, c := range keep {
+		add(c)
+	}
+
+	freq := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		freq = append(freq, c)
+	}
+	sort.Slice(freq, func(i, j int) bool {
+		ci, cj := freq[i], freq[j]
+		if counts[ci] != counts[cj] {
+			return counts[ci] > counts[cj]
+		}
+		return rgbaKey(ci) < rgbaKey(cj)
+	})
+	for _, c := range freq {
+		add(c)
+	}
+	return p
+}
+
+func rgbaKey(c color.RGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// paletteQuantizer is a draw.Quantizer that always returns a fixed palette.
+type paletteQuantizer color.Palette
+
+func (q paletteQuantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	return append(p[:0], q...)
+}
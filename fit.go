@@ -0,0 +1,91 @@
+package txt2png
+
+import (
+	"fmt"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// fitSize returns the largest font size, in points, at which lines fit in
+// cfg.FitWidth x cfg.FitHeight pixels; a zero dimension is unconstrained.
+// The text is measured as it is laid out, in slots slotW pixels wide or,
+// with cfg.TotalWidth, sharing that width: in slot mode it fits when every
+// glyph fits its slot, and in proportional mode when its advances do. Its
+// height runs from the ascent of the first line to the descent of the last.
+func fitSize(set fontSet, h font.Hinting, lines []string, styles [][]runeStyle, cfg Config, slotW int) float64 {
+	o := layoutOptions(cfg, slotW)
+	if cfg.TotalWidth > 0 && !cfg.Proportional && !cfg.Vertical {
+		numSlots := 0
+		for _, line := range lines {
+			numSlots = maxInt(numSlots, o.lineSlots(line))
+		}
+		o.shareSlots(cfg.TotalWidth, numSlots)
+	}
+	fits := func(size float64) bool {
+		faces := newFaceChain(set, cfg.DPI, size, h)
+		w := 0
+		for i, line := range lines {
+			glyphs, lw := layoutLine(faces, line, styles[i], o)
+			w = maxInt(w, lw)
+			if o.proportional || cfg.FitWidth <= 0 {
+				continue
+			}
+			slot := 0
+			for _, g := range glyphs {
+				n := o.slots(g.orig)
+				if g.advance > o.slotStart(slot+n)-o.slotStart(slot) {
+					return false
+				}
+				slot += n
+			}
+		}
+		m := faces.primary().Metrics()
+		h := m.Ascent.Ceil() + m.Descent.Ceil() + (len(lines)-1)*lineHeight(faces.primary(), cfg.LineSpacing)
+		return (cfg.FitWidth <= 0 || w <= cfg.FitWidth) && (cfg.FitHeight <= 0 || h <= cfg.FitHeight)
+	}
+
+	lo, hi := 1.0, 2.0
+	for fits(hi) && hi < 1<<14 {
+		lo, hi = hi, hi*2
+	}
+	for i := 0; i < 24; i++ {
+		mid := (lo + hi) / 2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// capHeightSize returns the font size, in points at dpi, at which the
+// capital letters of f are px pixels tall. The cap height comes from the
+// font's OS/2 table, or the top of its 'H' when the table doesn't give it.
+// A size whose glyphs would exceed maxPixels, as checkEm says, is an error.
+func capHeightSize(f *parsedFont, dpi float64, px, maxPixels int) (float64, error) {
+	// The cap height is measured at a large size to keep it exact.
+	const ppem = 1000
+	var capH fixed.Int26_6
+	if f.sfnt != nil {
+		var buf sfnt.Buffer
+		if m, err := f.sfnt.Metrics(&buf, fixed.I(ppem), font.HintingNone); err == nil {
+			capH = m.CapHeight
+		}
+	}
+	if capH <= 0 {
+		if b, _, ok := f.newFace(72, ppem, font.HintingNone).GlyphBounds('H'); ok {
+			capH = -b.Min.Y
+		}
+	}
+	if capH <= 0 {
+		return 0, fmt.Errorf("cannot size by cap height: the font has no capital letters to measure")
+	}
+	em := float64(px) * ppem / (float64(capH) / 64)
+	if err := checkEm(em, maxPixels); err != nil {
+		return 0, configErrorf("cap height of %d pixels: %w", px, err)
+	}
+	return em * 72 / dpi, nil
+}
@@ -0,0 +1,55 @@
+package txt2png
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestFittedImageMatchesBox(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		text         string
+		proportional bool
+		fitW, fitH   int
+		want         image.Point
+	}{
+		{"slots", "Button", false, 400, 300, image.Pt(400, 300)},
+		{"proportional", "Button", true, 400, 300, image.Pt(400, 300)},
+		{"two lines", "OK\nCancel", true, 300, 200, image.Pt(300, 200)},
+		{"width only", "Button", false, 410, 0, image.Pt(410, 0)},
+	} {
+		cfg := testConfig(tc.text)
+		cfg.Proportional, cfg.FitWidth, cfg.FitHeight = tc.proportional, tc.fitW, tc.fitH
+		res, err := Render(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		size := res.Image.Bounds().Size()
+		if size.X != tc.want.X || tc.want.Y > 0 && size.Y != tc.want.Y {
+			t.Errorf("%s: image is %v, want the %v box", tc.name, size, tc.want)
+		}
+		// The fitted text is drawn whole, inside the box.
+		ink := inkBounds(res.Image, nil, true)
+		if ink.Empty() || ink.Min.X < 1 || ink.Min.Y < 1 || ink.Max.X > size.X-1 || ink.Max.Y > size.Y-1 {
+			t.Errorf("%s: ink %v touches the edges of the %v image", tc.name, ink, size)
+		}
+		if res.Size < 20 {
+			t.Errorf("%s: fitted size %.2fpt, want the text to fill the box", tc.name, res.Size)
+		}
+	}
+}
+
+func TestHugeCapHeightFailsCleanly(t *testing.T) {
+	cfg := testConfig("H")
+	cfg.CapHeight = 100000
+	var ce *ConfigError
+	if _, err := Render(cfg); !errors.As(err, &ce) || !strings.Contains(err.Error(), "cap height of 100000 pixels") {
+		t.Fatalf("Render returned %v, want the cap height over the pixel limit as a *ConfigError", err)
+	}
+	cfg.CapHeight = 30
+	if _, err := Render(cfg); err != nil {
+		t.Fatalf("a 30px cap height: %v", err)
+	}
+}
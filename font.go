@@ -0,0 +1,291 @@
+package txt2png
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// defaultFontFile is the font looked up in the working directory when no
+// other font is chosen.
+const defaultFontFile = "./LiberationMono-Regular.ttf"
+
+// embeddedFont is Liberation Mono (SIL Open Font License), compiled into
+// the binary so that the default works without any font file around.
+//
+//go:embed LiberationMono-Regular.ttf
+var embeddedFont []byte
+
+// parsedFont is a loaded font file. TrueType outlines are rendered with
+// freetype; OpenType fonts with CFF outlines, which freetype rejects, are
+// rendered through sfnt. sfnt is also used for tables such as 'post' that
+// freetype ignores.
+type parsedFont struct {
+	tt   *truetype.Font // nil for CFF-flavored OpenType fonts
+	sfnt *sfnt.Font     // nil if sfnt cannot read the file
+}
+
+// FontError reports a font that could not be found, read or parsed, as
+// opposed to a problem with the text or the other settings.
+type FontError struct {
+	Font string // file name, or the name given in Config.Font
+	Err  error
+}
+
+func (e *FontError) Error() string { return e.Err.Error() }
+
+func (e *FontError) Unwrap() error { return e.Err }
+
+// fontCache holds the fonts parsed by loadFont, keyed by absolute path
+// ("" for the embedded font), so that batch renders parse each file once.
+// A file is not re-read if it changes later.
+var fontCache sync.Map
+
+// loadFont reads and parses the font at path, or returns it from fontCache
+// if it was loaded before. An empty path, or the default path when that
+// file doesn't exist, selects the embedded font.
+func loadFont(path string, verb bool) (*parsedFont, error) {
+	fontBytes := embeddedFont
+	key := ""
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, &FontError{path, fmt.Errorf("reading font file: %w", err)}
+		}
+		if f, ok := fontCache.Load(abs); ok {
+			return f.(*parsedFont), nil
+		}
+		b, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if verb {
+				fmt.Fprintf(os.Stderr, "Loading fontfile %q\n", path)
+			}
+			fontBytes, key = b, abs
+		case path == defaultFontFile && errors.Is(err, fs.ErrNotExist):
+			path = ""
+		default:
+			return nil, &FontError{path, fmt.Errorf("reading font file: %w", err)}
+		}
+	}
+	if path == "" {
+		if f, ok := fontCache.Load(key); ok {
+			return f.(*parsedFont), nil
+		}
+		if verb {
+			fmt.Fprintf(os.Stderr, "Using embedded font\n")
+		}
+	}
+	f := &parsedFont{}
+	var err error
+	f.tt, err = truetype.Parse(fontBytes)
+	if err != nil {
+		f.tt = nil
+	}
+	f.sfnt, err = sfnt.Parse(fontBytes)
+	if err != nil {
+		f.sfnt = nil
+	}
+	if f.tt == nil && f.sfnt == nil {
+		return nil, &FontError{path, fmt.Errorf("parsing font %s: not a TrueType or OpenType font", path)}
+	}
+	// Two renders may parse the same file at once; both get the first
+	// one stored.
+	cached, _ := fontCache.LoadOrStore(key, f)
+	return cached.(*parsedFont), nil
+}
+
+// newFace returns a face for f at the given size and resolution.
+func (f *parsedFont) newFace(dpi, size float64, h font.Hinting) font.Face {
+	if f.tt != nil {
+		return truetype.NewFace(f.tt, &truetype.Options{
+			Size:    size,
+			DPI:     dpi,
+			Hinting: h,
+		})
+	}
+	// opentype.NewFace only fails for invalid options, which these aren't.
+	face, _ := opentype.NewFace(f.sfnt, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     dpi,
+		Hinting: h,
+	})
+	return face
+}
+
+// hasGlyph reports whether f maps r to a real glyph rather than .notdef.
+func (f *parsedFont) hasGlyph(r rune) bool {
+	if f.tt != nil {
+		return f.tt.Index(r) != 0
+	}
+	var buf sfnt.Buffer
+	idx, err := f.sfnt.GlyphIndex(&buf, r)
+	return err == nil && idx != 0
+}
+
+// fontSet is the fonts a face chain is made of: a primary font followed
+// by fallbacks for the runes it lacks, then extra fonts that are not
+// fallbacks but draw only the runes that markup assigns to them.
+type fontSet struct {
+	fonts  []*parsedFont
+	extra  int
+	levels int // nesting depth of sub- and superscripts, which need smaller faces
+}
+
+// faceChain holds a face for every font of its set: first at the chain's
+// size, then for each script level at scriptScale times the size of the
+// level before. Face i of level l is faces[l*len(fonts)+i].
+type faceChain struct {
+	fontSet
+	faces []font.Face
+	em    float64 // pixels per em at the chain's size
+}
+
+// scriptScale is the size of sub- and superscripts relative to the text
+// around them.
+const scriptScale = 0.6
+
+func newFaceChain(set fontSet, dpi, size float64, h font.Hinting) faceChain {
+	fc := faceChain{fontSet: set, em: size * dpi / 72}
+	for l := 0; l <= set.levels; l++ {
+		for _, f := range set.fonts {
+			fc.faces = append(fc.faces, f.newFace(dpi, size, h))
+		}
+		size *= scriptScale
+	}
+	return fc
+}
+
+// primary returns the face of the main font, which provides the metrics.
+func (fc faceChain) primary() font.Face {
+	return fc.faces[0]
+}
+
+// glyph returns the index in faces of the face that draws r in style st,
+// and its advance: that of st.font, unless it is negative or lacks r, else
+// that of the first font of the chain that has r. ok is false if no font
+// has one.
+func (fc faceChain) glyph(r rune, st runeStyle) (idx int, advance fixed.Int26_6, ok bool) {
+	base := st.level * len(fc.fonts)
+	if st.font >= 0 && fc.fonts[st.font].hasGlyph(r) {
+		advance, ok = fc.faces[base+st.font].GlyphAdvance(r)
+		return base + st.font, advance, ok
+	}
+	for i, f := range fc.fonts[:len(fc.fonts)-fc.extra] {
+		if f.hasGlyph(r) {
+			advance, ok = fc.faces[base+i].GlyphAdvance(r)
+			return base + i, advance, ok
+		}
+	}
+	return base, 0, false
+}
+
+// parseHinting returns the hinting mode called s. TrueType faces apply
+// "vertical" as full hinting, as the truetype package has no vertical-only
+// mode.
+func parseHinting(s string) (font.Hinting, error) {
+	switch s {
+	case "none":
+		return font.HintingNone, nil
+	case "vertical":
+		return font.HintingVertical, nil
+	case "full":
+		return font.HintingFull, nil
+	}
+	return font.HintingNone, configErrorf("invalid hinting %q (want none, vertical or full)", s)
+}
+
+// fontDirs returns the directories FindFont searches, in order: the
+// user's own font directories first, then the system-wide ones of Linux,
+// macOS and Windows. Directories that don't exist are skipped.
+func fontDirs() []string {
+	var dirs []string
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs,
+			filepath.Join(home, ".fonts"),
+			filepath.Join(home, ".local", "share", "fonts"),
+			filepath.Join(home, "Library", "Fonts"),
+		)
+	}
+	if local := os.Getenv("LOCALAPPDATA"); local != "" {
+		dirs = append(dirs, filepath.Join(local, "Microsoft", "Windows", "Fonts"))
+	}
+	dirs = append(dirs,
+		"/usr/local/share/fonts",
+		"/usr/share/fonts",
+		"/Library/Fonts",
+		"/System/Library/Fonts",
+	)
+	windir := os.Getenv("WINDIR")
+	if windir == "" {
+		windir = `C:\Windows`
+	}
+	return append(dirs, filepath.Join(windir, "Fonts"))
+}
+
+// FindFont returns the path of an installed .ttf or .otf font whose family
+// or full name (as recorded in the font's name table) equals name, ignoring
+// case. If several files match, an exact full-name match wins, then the
+// regular style of the family, then any style; remaining ties go to the
+// file found first in the search order of the font directories.
+func FindFont(name string) (string, error) {
+	best, bestScore := "", 0
+	for _, dir := range fontDirs() {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || bestScore == 3 {
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".ttf", ".otf":
+			default:
+				return nil
+			}
+			if score := fontNameScore(path, name); score > bestScore {
+				best, bestScore = path, score
+			}
+			return nil
+		})
+	}
+	if best == "" {
+		return "", fmt.Errorf("no installed font named %q", name)
+	}
+	return best, nil
+}
+
+// fontNameScore rates how well the font at path matches name: 3 for its
+// full name, 2 for the regular style of its family, 1 for another style of
+// the family and 0 for no match.
+func fontNameScore(path, name string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	f, err := sfnt.Parse(b)
+	if err != nil {
+		return 0
+	}
+	var buf sfnt.Buffer
+	if full, err := f.Name(&buf, sfnt.NameIDFull); err == nil && strings.EqualFold(full, name) {
+		return 3
+	}
+	family, err := f.Name(&buf, sfnt.NameIDFamily)
+	if err != nil || !strings.EqualFold(family, name) {
+		return 0
+	}
+	switch style, _ := f.Name(&buf, sfnt.NameIDSubfamily); strings.ToLower(style) {
+	case "regular", "book", "normal":
+		return 2
+	}
+	return 1
+}
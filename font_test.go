@@ -0,0 +1,36 @@
+package txt2png
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFontParsedOnceAcrossRenders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mono.ttf")
+	if err := os.WriteFile(path, embeddedFont, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := loadFont(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Renders after the file is gone can only use the parsed font.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig("cached")
+	cfg.FontFile = path
+	for i := 0; i < 5; i++ {
+		if _, err := Render(cfg); err != nil {
+			t.Fatalf("render %d: %v", i, err)
+		}
+	}
+	f, err := loadFont(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != first {
+		t.Error("the font was parsed again")
+	}
+}
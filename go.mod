@@ -5,4 +5,5 @@ go 1.19
 require (
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	golang.org/x/image v0.2.0
+	golang.org/x/text v0.5.0
 )
@@ -0,0 +1,142 @@
+package txt2png
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// gradient is an image of unbounded extent whose color goes linearly, in
+// RGB, from `from` to `to` across r in the direction dir: h (left to right),
+// v (top to bottom) or diag (top left to bottom right). Outside r the end
+// colors continue.
+type gradient struct {
+	from, to color.RGBA
+	dir      string
+	r        image.Rectangle
+}
+
+// parseGradient parses a gradient written as "from -> to", each end in
+// the syntax of parseHexColor.
+func parseGradient(s, dir string, r image.Rectangle) (*gradient, error) {
+	ends := strings.Split(s, "->")
+	if len(ends) != 2 {
+		return nil, configErrorf("gradient %q is not of the form \"#from -> #to\"", s)
+	}
+	from, err := parseHexColor(strings.TrimSpace(ends[0]))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseHexColor(strings.TrimSpace(ends[1]))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGradientDir(dir); err != nil {
+		return nil, err
+	}
+	return &gradient{from, to, dir, r}, nil
+}
+
+func checkGradientDir(dir string) error {
+	switch dir {
+	case "", "h", "v", "diag":
+		return nil
+	}
+	return configErrorf("invalid gradient direction %q (want h, v or diag)", dir)
+}
+
+func (g *gradient) ColorModel() color.Model { return color.RGBAModel }
+
+func (g *gradient) Bounds() image.Rectangle {
+	return image.Rectangle{image.Point{-1e9, -1e9}, image.Point{1e9, 1e9}}
+}
+
+func (g *gradient) At(x, y int) color.Color {
+	frac := func(v, lo, hi int) float64 {
+		if hi-lo <= 1 {
+			return 0
+		}
+		t := float64(v-lo) / float64(hi-lo-1)
+		if t < 0 {
+			return 0
+		}
+		if t > 1 {
+			return 1
+		}
+		return t
+	}
+	tx, ty := frac(x, g.r.Min.X, g.r.Max.X), frac(y, g.r.Min.Y, g.r.Max.Y)
+	var t float64
+	switch g.dir {
+	case "v":
+		t = ty
+	case "diag":
+		t = (tx + ty) / 2
+	default:
+		t = tx
+	}
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5)
+	}
+	return color.RGBA{mix(g.from.R, g.to.R), mix(g.from.G, g.to.G), mix(g.from.B, g.to.B), mix(g.from.A, g.to.A)}
+}
+
+// rulers returns a gradient of guideline colors that contrast with g along
+// its whole length.
+func (g *gradient) rulers() *gradient {
+	return &gradient{contrastingRuler(g.from), contrastingRuler(g.to), g.dir, g.r}
+}
+
+// bandGradient applies a gradient separately to each of its bands, so that
+// every line of text runs through the whole gradient. A point outside all
+// bands takes the color of the nearest one.
+type bandGradient struct {
+	g     gradient
+	bands []image.Rectangle
+}
+
+func (b *bandGradient) ColorModel() color.Model { return color.RGBAModel }
+
+func (b *bandGradient) Bounds() image.Rectangle { return b.g.Bounds() }
+
+func (b *bandGradient) At(x, y int) color.Color {
+	dist := func(r image.Rectangle) int {
+		d := func(v, lo, hi int) int {
+			switch {
+			case v < lo:
+				return lo - v
+			case v >= hi:
+				return v - hi + 1
+			}
+			return 0
+		}
+		dx, dy := d(x, r.Min.X, r.Max.X), d(y, r.Min.Y, r.Max.Y)
+		return dx*dx + dy*dy
+	}
+	g := b.g
+	best := -1
+	for _, r := range b.bands {
+		if d := dist(r); best < 0 || d < best {
+			best, g.r = d, r
+		}
+	}
+	return g.At(x, y)
+}
+
+// textBands returns the boxes that a text gradient spans, from ascent
+// above the baseline to descent below it: one per line, or one per glyph
+// cell for vertical text.
+func textBands(tl textLayout, ascent, descent int) []image.Rectangle {
+	var bands []image.Rectangle
+	for row, o := range tl.origins {
+		if !tl.vertical {
+			bands = append(bands, image.Rect(o.X, o.Y-ascent, o.X+tl.widths[row], o.Y+descent))
+			continue
+		}
+		for _, g := range tl.lines[row] {
+			x, y := o.X+g.x, o.Y+g.y
+			bands = append(bands, image.Rect(x, y-ascent, x+g.advance, y+descent))
+		}
+	}
+	return bands
+}
@@ -0,0 +1,875 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/width"
+)
+
+// textLayout is the text placed on the canvas, ready to be drawn.
+type textLayout struct {
+	lines    [][]glyphPos
+	widths   []int           // width in pixels of each horizontal line
+	origins  []image.Point   // start of each line's baseline
+	vertical bool            // lines are columns running top to bottom
+	guidesX  []int           // x of the vertical guidelines
+	guidesY  []int           // y of the horizontal guidelines
+	slotEnd  int             // x (y for vertical text) where the last slot ends
+	caption  image.Rectangle // where the caption goes, if there is one
+}
+
+// translate moves the whole layout by d.
+func (tl *textLayout) translate(d image.Point) {
+	for i := range tl.origins {
+		tl.origins[i] = tl.origins[i].Add(d)
+	}
+	for i := range tl.guidesX {
+		tl.guidesX[i] += d.X
+	}
+	for i := range tl.guidesY {
+		tl.guidesY[i] += d.Y
+	}
+	if tl.vertical {
+		tl.slotEnd += d.Y
+	} else {
+		tl.slotEnd += d.X
+	}
+	tl.caption = tl.caption.Add(d)
+}
+
+// slotRects returns the box of every slot, in order, as a band across the
+// whole of bounds: a column, or a row for vertical text. It is empty in
+// proportional mode.
+func (tl textLayout) slotRects(bounds image.Rectangle) []image.Rectangle {
+	edges := tl.guidesX
+	if tl.vertical {
+		edges = tl.guidesY
+	}
+	rects := make([]image.Rectangle, len(edges))
+	for i, lo := range edges {
+		hi := tl.slotEnd
+		if i+1 < len(edges) {
+			hi = edges[i+1]
+		}
+		if tl.vertical {
+			rects[i] = image.Rect(bounds.Min.X, lo, bounds.Max.X, hi)
+		} else {
+			rects[i] = image.Rect(lo, bounds.Min.Y, hi, bounds.Max.Y)
+		}
+	}
+	return rects
+}
+
+// baselines returns the distinct y coordinates of the baselines of tl, top
+// to bottom: one per line, or one per cell row for vertical text.
+func (tl textLayout) baselines() []int {
+	var ys []int
+	seen := make(map[int]bool)
+	add := func(y int) {
+		if !seen[y] {
+			seen[y] = true
+			ys = append(ys, y)
+		}
+	}
+	for row, o := range tl.origins {
+		if !tl.vertical {
+			add(o.Y)
+			continue
+		}
+		for _, g := range tl.lines[row] {
+			add(o.Y + g.y)
+		}
+	}
+	sort.Ints(ys)
+	return ys
+}
+
+// inkRect returns the box that the ink of the glyphs of tl covers,
+// leaving out sub- and superscripts nested more than levels deep. ok is
+// false if no glyph has any ink.
+func (tl textLayout) inkRect(faces faceChain, levels int) (r image.Rectangle, ok bool) {
+	for row, glyphs := range tl.lines {
+		for _, g := range glyphs {
+			if !g.ok || g.level > levels {
+				continue
+			}
+			b, _, found := faces.faces[g.font].GlyphBounds(g.r)
+			if !found || b.Empty() {
+				continue
+			}
+			o := tl.origins[row].Add(image.Pt(g.x, g.y))
+			ink := image.Rect(o.X+(b.Min.X+g.sub).Floor(), o.Y+b.Min.Y.Floor(), o.X+(b.Max.X+g.sub).Ceil(), o.Y+b.Max.Y.Ceil())
+			if ok {
+				r = r.Union(ink)
+			} else {
+				r, ok = ink, true
+			}
+		}
+	}
+	return r, ok
+}
+
+// glyphPt returns the pen position of glyph g of line row, shifted by d.
+func (tl textLayout) glyphPt(row int, g glyphPos, d image.Point) fixed.Point26_6 {
+	o := tl.origins[row].Add(d)
+	return fixed.Point26_6{X: fixed.I(o.X+g.x) + g.sub, Y: fixed.I(o.Y + g.y)}
+}
+
+// glyphPos is a rune placed on a line.
+type glyphPos struct {
+	r       rune          // rune drawn
+	orig    rune          // rune of the text; differs from r for '?' placeholders
+	x, y    int           // pen position of the glyph origin, relative to the line origin
+	sub     fixed.Int26_6 // fraction of a pixel to the right of x, with subpixel positioning
+	advance int           // advance width in pixels, rounded
+	adv     fixed.Int26_6 // advance width as the font gives it
+	font    int           // index in the face chain of the face that draws r
+	level   int           // nesting depth of sub- and superscripts around the glyph
+	gutter  bool          // a line number, drawn in the gutter color
+	ok      bool          // whether r is drawn from the face chain
+	missing bool          // no font of the chain has the rune originally at this position
+	box     bool          // draw a placeholder box instead of a glyph
+}
+
+// layoutOpts are the settings that decide where glyphs go on a line.
+type layoutOpts struct {
+	slotW        int
+	tracking     int
+	halign       string
+	proportional bool
+	missing      string // what to do with runes no font has: skip, box or question
+	tabWidth     int    // tab stops every this many space widths, in proportional mode
+	kern         bool   // apply the font's kerning between pairs, in proportional mode
+	slotEdges    []int  // if set, slot i spans slotEdges[i] to slotEdges[i+1] instead of slotW pixels
+	wide         bool   // East Asian wide and fullwidth runes take two slots, in slot mode
+	tabular      bool   // give the digits 0-9 the advance of the widest one, in proportional mode
+	subpixel     bool   // keep fractional pen positions instead of rounding each advance, in proportional mode
+}
+
+// round rounds v to whole pixels unless o keeps subpixel positions.
+func (o layoutOpts) round(v fixed.Int26_6) fixed.Int26_6 {
+	if o.subpixel {
+		return v
+	}
+	return fixed.I(v.Round())
+}
+
+// advance returns the advance of g that the pen moves by.
+func (o layoutOpts) advance(g glyphPos) fixed.Int26_6 {
+	if o.subpixel {
+		return g.adv
+	}
+	return fixed.I(g.advance)
+}
+
+// place sets the position of g to p, split into whole pixels and the
+// fraction left over.
+func (g *glyphPos) place(p fixed.Int26_6) {
+	g.x = p.Floor()
+	g.sub = p - fixed.I(g.x)
+}
+
+// slots returns the number of slots that r takes in slot mode.
+func (o layoutOpts) slots(r rune) int {
+	if o.wide {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			return 2
+		}
+	}
+	return 1
+}
+
+// lineSlots returns the number of slots that line takes in slot mode.
+func (o layoutOpts) lineSlots(line string) int {
+	n := 0
+	for _, r := range line {
+		n += o.slots(r)
+	}
+	return n
+}
+
+// glyph resolves r in style st against faces. Runes that no font has are
+// skipped, or replaced by a '?' or a box the size of a '?' depending on
+// o.missing. Sub- and superscripts are raised or lowered from the
+// baseline.
+func (o layoutOpts) glyph(faces faceChain, r rune, st runeStyle) glyphPos {
+	idx, advance, ok := faces.glyph(r, st)
+	g := glyphPos{r: r, orig: r, advance: advance.Round(), adv: advance, font: idx, level: st.level, gutter: st.gutter, ok: ok}
+	g.y = -int(math.Round(st.rise * faces.em))
+	if ok || o.missing == "skip" || o.missing == "" {
+		g.missing = !ok
+		return g
+	}
+	q := o.glyph(faces, '?', st)
+	q.orig, q.missing = r, true
+	if o.missing == "box" {
+		q.ok, q.box = false, true
+	}
+	return q
+}
+
+func checkMissingGlyph(mode string) error {
+	switch mode {
+	case "skip", "box", "question", "":
+		return nil
+	}
+	return configErrorf("unknown missing-glyph mode %q (want skip, box or question)", mode)
+}
+
+// layoutOptions returns the options that lay out the lines of cfg, in
+// slots slotW pixels wide in slot mode.
+func layoutOptions(cfg Config, slotW int) layoutOpts {
+	return layoutOpts{
+		slotW:        slotW,
+		tracking:     cfg.Tracking,
+		halign:       cfg.HAlign,
+		proportional: cfg.Proportional,
+		missing:      cfg.MissingGlyph,
+		tabWidth:     cfg.TabWidth,
+		kern:         !cfg.NoKern,
+		wide:         cfg.WideCells && !cfg.Vertical && !cfg.Proportional && !(cfg.Justify && cfg.TotalWidth > 0),
+		tabular:      cfg.TabularNums,
+		subpixel:     cfg.Subpixel,
+	}
+}
+
+// shareSlots makes the first n slots of o share total pixels out between
+// them, spreading the remainder.
+func (o *layoutOpts) shareSlots(total, n int) {
+	n = maxInt(n, 1)
+	o.slotEdges = o.slotEdges[:0]
+	for i := 0; i <= n; i++ {
+		o.slotEdges = append(o.slotEdges, i*total/n)
+	}
+}
+
+// buildLayout places lines on the canvas and returns the layout together
+// with the canvas size. Horizontal lines are stacked lineH pixels apart
+// below a first baseline at y=baseline. In vertical mode each line becomes
+// a slotW-wide column, the first one on the right as in traditional CJK
+// typesetting, and each rune gets a cell of cfg.Height pixels.
+func buildLayout(faces faceChain, lines []string, styles [][]runeStyle, cfg Config, slotW, baseline, lineH int) (tl textLayout, width, height int) {
+	justify := cfg.Justify && cfg.TotalWidth > 0
+	opts := layoutOptions(cfg, slotW)
+	// src maps each laid out line to the line of the text it comes from,
+	// which wrapping may have split.
+	src := make([]int, len(lines))
+	for i := range src {
+		src[i] = i
+	}
+	if cfg.Wrap > 0 && !cfg.Vertical {
+		var wrapped []string
+		var wrappedStyles [][]runeStyle
+		src = src[:0]
+		for i := range lines {
+			// A line number stays out of the wrapping: it goes before the
+			// first piece, and the others get a blank gutter.
+			runes, n := []rune(lines[i]), gutterLen(styles[i])
+			gutter, gutterSt, maxW := string(runes[:n]), styles[i][:n], cfg.Wrap
+			if n > 0 {
+				_, w := layoutLine(faces, gutter, gutterSt, opts)
+				maxW = maxInt(maxW-w, 1)
+			}
+			l, st := wrapLines(faces, []string{string(runes[n:])}, [][]runeStyle{styles[i][n:]}, opts, maxW)
+			for j := range l {
+				if j > 0 {
+					gutter = strings.Repeat(" ", n)
+				}
+				l[j], st[j] = gutter+l[j], append(append([]runeStyle(nil), gutterSt...), st[j]...)
+			}
+			wrapped, wrappedStyles = append(wrapped, l...), append(wrappedStyles, st...)
+			for range l {
+				src = append(src, i)
+			}
+		}
+		lines, styles = wrapped, wrappedStyles
+	}
+	tl = textLayout{
+		lines:    make([][]glyphPos, len(lines)),
+		widths:   make([]int, len(lines)),
+		origins:  make([]image.Point, len(lines)),
+		vertical: cfg.Vertical,
+	}
+	numSlots := 0
+	for _, line := range lines {
+		numSlots = maxInt(numSlots, opts.lineSlots(line))
+	}
+	if cfg.TotalWidth > 0 && !cfg.Proportional && !cfg.Vertical {
+		opts.shareSlots(cfg.TotalWidth, numSlots)
+	}
+
+	if cfg.Vertical {
+		for i, line := range lines {
+			tl.lines[i] = layoutColumn(faces, line, styles[i], opts, cfg.Height)
+			tl.origins[i] = image.Pt((len(lines)-1-i)*slotW, baseline)
+		}
+		for i := 0; i < numSlots; i++ {
+			tl.guidesY = append(tl.guidesY, i*cfg.Height)
+		}
+		tl.slotEnd = numSlots * cfg.Height
+		return tl, len(lines) * slotW, maxInt(numSlots, 1) * cfg.Height
+	}
+
+	if cfg.Proportional || justify {
+		// Guidelines mark slot boundaries, which proportional mode and
+		// justified lines lack.
+		numSlots = 0
+	}
+	for i, line := range lines {
+		tl.lines[i], tl.widths[i] = layoutLine(faces, line, styles[i], opts)
+		if justify {
+			justifyLine(tl.lines[i], cfg.TotalWidth, cfg.RTL)
+			tl.widths[i] = cfg.TotalWidth
+		}
+		tl.origins[i] = image.Pt(0, baseline+i*lineH)
+	}
+	if cfg.Proportional && !justify {
+		alignGutters(tl.lines, tl.widths)
+	}
+	for _, w := range tl.widths {
+		width = maxInt(width, w)
+	}
+	// Wide runes span two slots, so no guideline may cut through them on
+	// any line.
+	inside := make([]bool, numSlots)
+	for _, line := range lines {
+		n := 0
+		for _, r := range line {
+			for k := 1; k < opts.slots(r); k++ {
+				inside[n+k] = true
+			}
+			n += opts.slots(r)
+		}
+	}
+	for i := 0; i < numSlots; i++ {
+		if !inside[i] {
+			tl.guidesX = append(tl.guidesX, opts.slotStart(i))
+		}
+	}
+	tl.slotEnd = opts.slotStart(numSlots)
+	if width == 0 {
+		// Empty text still gets a blank slot, even in proportional mode
+		// where SlotWidth need not be usable.
+		width = maxInt(opts.slotStart(1), 1)
+	}
+	if cfg.RTL && !justify {
+		for i := range tl.lines {
+			mirrorLine(tl.lines[i], tl.widths[i], opts)
+		}
+	}
+	// Already checked by layoutPage.
+	aligns, _ := parseAlign(cfg.Align)
+	for i := range tl.lines {
+		switch lineAlign(aligns, src[i], cfg.RTL) {
+		case "center":
+			tl.origins[i].X += (width - tl.widths[i]) / 2
+		case "right":
+			tl.origins[i].X += width - tl.widths[i]
+		}
+	}
+	height = cfg.Height
+	if len(lines) > 1 {
+		height += (len(lines) - 1) * lineH
+	}
+	return tl, width, height
+}
+
+// wrapLines breaks each line at spaces so that no line is wider than maxW
+// pixels, and returns the new lines with the styles of their runes. Words
+// that are wider than maxW on their own are broken between characters.
+// Runs of spaces at a break are dropped, and the others become one space
+// styled like the first of them.
+func wrapLines(faces faceChain, lines []string, styles [][]runeStyle, o layoutOpts, maxW int) ([]string, [][]runeStyle) {
+	var out []string
+	var outStyles [][]runeStyle
+	for i, line := range lines {
+		runes, st := []rune(line), styles[i]
+		var cur []rune
+		var curSt []runeStyle
+		fits := func(r []rune, s []runeStyle) bool {
+			_, w := layoutLine(faces, string(r), s, o)
+			return w <= maxW
+		}
+		emit := func() {
+			out = append(out, string(cur))
+			outStyles = append(outStyles, curSt)
+			cur, curSt = nil, nil
+		}
+		sep := runeStyle{font: -1}
+		for j := 0; j < len(runes); {
+			if unicode.IsSpace(runes[j]) {
+				if j == 0 || !unicode.IsSpace(runes[j-1]) {
+					sep = st[j]
+				}
+				j++
+				continue
+			}
+			k := j
+			for k < len(runes) && !unicode.IsSpace(runes[k]) {
+				k++
+			}
+			word, wordSt := runes[j:k], st[j:k]
+			j = k
+			if len(cur) > 0 {
+				r := append(append(append([]rune(nil), cur...), ' '), word...)
+				s := append(append(append([]runeStyle(nil), curSt...), sep), wordSt...)
+				if fits(r, s) {
+					cur, curSt = r, s
+					continue
+				}
+				emit()
+			}
+			// Hard-break words that don't fit on a line of their own.
+			for n := range word {
+				if len(cur) > 0 && !fits(append(cur[:len(cur):len(cur)], word[n]), append(curSt[:len(curSt):len(curSt)], wordSt[n])) {
+					emit()
+				}
+				cur = append(cur, word[n])
+				curSt = append(curSt, wordSt[n])
+			}
+		}
+		emit()
+	}
+	return out, outStyles
+}
+
+// layoutColumn places the runes of line one below the other in cells of
+// o.slotW x cellH pixels, aligned horizontally within the column by
+// o.halign.
+func layoutColumn(faces faceChain, line string, styles []runeStyle, o layoutOpts, cellH int) []glyphPos {
+	runes := []rune(line)
+	glyphs := make([]glyphPos, len(runes))
+	for i, r := range runes {
+		g := o.glyph(faces, r, styles[i])
+		g.x = slotOffset(faces, o.halign, o.slotW, g)
+		g.y += i * cellH
+		glyphs[i] = g
+	}
+	return glyphs
+}
+
+// layoutLine places the runes of line. In slot mode every rune is aligned
+// within its own slot (see slotStart) according to o.halign; in proportional
+// mode the runes follow each other by their advance widths plus o.tracking
+// pixels. Negative tracking never moves the pen back past the start of the
+// previous glyph, so nothing is pushed off the left edge. It returns the
+// placed glyphs and the width of the line in pixels.
+func layoutLine(faces faceChain, line string, styles []runeStyle, o layoutOpts) ([]glyphPos, int) {
+	// Range over runes rather than bytes so that multibyte characters
+	// still land in consecutive slots.
+	runes := []rune(line)
+	glyphs := make([]glyphPos, len(runes))
+	width, slot := 0, 0
+	var pen fixed.Int26_6
+	var prev *glyphPos
+	for i, r := range runes {
+		if r == '\t' && o.proportional {
+			// Tabs move the pen to the next stop and draw nothing.
+			_, space, _ := faces.glyph(' ', styles[i])
+			if stop := o.round(space) * fixed.Int26_6(maxInt(o.tabWidth, 1)); stop > 0 {
+				pen = (pen/stop + 1) * stop
+			}
+			glyphs[i] = glyphPos{r: r, orig: r}
+			glyphs[i].place(pen)
+			prev = nil
+			continue
+		}
+		g := o.glyph(faces, r, styles[i])
+		if o.proportional {
+			tab := o.tabular && g.ok && isDigit(g.r)
+			if o.kern && prev != nil && prev.ok && g.ok && prev.font == g.font && !(tab && isDigit(prev.r)) {
+				pen += o.round(faces.faces[g.font].Kern(prev.r, g.r))
+			}
+			advance := o.advance(g)
+			g.place(pen)
+			if tab {
+				// Center the digit in the advance of the widest one.
+				own := advance
+				advance = o.round(digitWidth(faces, g.font))
+				g.place(pen + (advance-own)/2)
+			}
+			width = maxInt(width, (pen + advance).Ceil())
+			if next := pen + advance + fixed.I(o.tracking); next > pen {
+				pen = next
+			}
+		} else {
+			n := o.slots(r)
+			x0 := o.slotStart(slot)
+			g.x = x0 + slotOffset(faces, o.halign, o.slotStart(slot+n)-x0, g)
+			slot += n
+		}
+		glyphs[i] = g
+		prev = &glyphs[i]
+	}
+	if !o.proportional {
+		return glyphs, o.slotStart(slot)
+	}
+	return glyphs, width
+}
+
+func isDigit(r rune) bool { return r >= '0' && r <= '9' }
+
+// digitWidth returns the advance of the widest of the digits 0-9 in face
+// i of faces.
+func digitWidth(faces faceChain, i int) fixed.Int26_6 {
+	var w fixed.Int26_6
+	for r := '0'; r <= '9'; r++ {
+		if adv, ok := faces.faces[i].GlyphAdvance(r); ok && adv > w {
+			w = adv
+		}
+	}
+	return w
+}
+
+// slotStart returns the x of the left edge of slot i.
+func (o layoutOpts) slotStart(i int) int {
+	if o.slotEdges == nil {
+		return i * o.slotW
+	}
+	if i >= len(o.slotEdges) {
+		// Lines longer than the grid (after wrapping) continue it.
+		last := len(o.slotEdges) - 1
+		return o.slotEdges[last] + (i-last)*o.slotW
+	}
+	return o.slotEdges[i]
+}
+
+// mirrorLine reverses the visual order of glyphs laid out by layoutLine
+// on a line w pixels wide, so that the first one ends up on the right. In
+// slot mode glyphs keep their place within their slot.
+func mirrorLine(glyphs []glyphPos, w int, o layoutOpts) {
+	n := 0
+	for _, g := range glyphs {
+		n += o.slots(g.orig)
+	}
+	slot := 0
+	for i := range glyphs {
+		g := &glyphs[i]
+		if o.proportional {
+			g.place(fixed.I(w-g.x) - g.sub - o.advance(*g))
+			continue
+		}
+		k := o.slots(g.orig)
+		g.x += o.slotStart(n-slot-k) - o.slotStart(slot)
+		slot += k
+	}
+}
+
+// justifyLine spreads glyphs over a line w pixels wide, with equal gaps
+// between their advances, so that the first starts at the left edge and the
+// last ends at the right edge (the other way round if rtl). A single glyph
+// is centered. Text wider than w gets negative gaps and overlaps.
+func justifyLine(glyphs []glyphPos, w int, rtl bool) {
+	n := len(glyphs)
+	if n == 0 {
+		return
+	}
+	free := w
+	for _, g := range glyphs {
+		free -= g.advance
+	}
+	pen := 0
+	for i := range glyphs {
+		g := &glyphs[i]
+		switch {
+		case n == 1:
+			g.x = free / 2
+		default:
+			g.x = pen + i*free/(n-1)
+		}
+		g.sub = 0
+		pen += g.advance
+		if rtl {
+			g.x = w - g.x - g.advance
+		}
+	}
+}
+
+// slotOffset returns the x offset within a slot of glyph g: flush with the
+// slot's left or right edge by its advance, or centered by its ink so that
+// side bearings don't push it off center. Glyphs without ink are centered
+// by their advance.
+func slotOffset(faces faceChain, halign string, slotW int, g glyphPos) int {
+	switch halign {
+	case "left":
+		return 0
+	case "right":
+		return slotW - g.advance
+	}
+	if g.ok {
+		if b, _, ok := faces.faces[g.font].GlyphBounds(g.r); ok && !b.Empty() {
+			return slotW/2 - ((b.Min.X + b.Max.X) / 2).Round()
+		}
+	}
+	return slotW/2 - g.advance/2
+}
+
+// parseAlign parses a line alignment spec, a comma-separated list of l,
+// c and r (or left, center and right), one for each line of the text.
+func parseAlign(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var aligns []string
+	for _, a := range strings.Split(spec, ",") {
+		switch a = strings.TrimSpace(a); a {
+		case "l", "left":
+			aligns = append(aligns, "left")
+		case "c", "center":
+			aligns = append(aligns, "center")
+		case "r", "right":
+			aligns = append(aligns, "right")
+		default:
+			return nil, configErrorf("unknown line alignment %q in %q (want l, c or r)", a, spec)
+		}
+	}
+	return aligns, nil
+}
+
+// lineAlign returns the alignment of line i of the text within the text
+// block: entry i of aligns, the last entry for lines beyond the list, or
+// without any, left or right for rtl text.
+func lineAlign(aligns []string, i int, rtl bool) string {
+	switch {
+	case len(aligns) > 0:
+		return aligns[minInt(i, len(aligns)-1)]
+	case rtl:
+		return "right"
+	}
+	return "left"
+}
+
+func checkHAlign(halign string) error {
+	switch halign {
+	case "left", "center", "right", "":
+		return nil
+	}
+	return configErrorf("unknown horizontal alignment %q (want left, center or right)", halign)
+}
+
+// baselineY returns the baseline of the first line within a row of height
+// imgH for the given vertical alignment:
+//
+//   - top: the font's ascent touches the top edge; descenders hang below.
+//   - center: the box from ascent to descent is centered, so descenders
+//     (g, y, p) are kept inside the row.
+//   - bottom: the descent touches the bottom edge, leaving room for
+//     descenders.
+//   - baseline: only the part above the baseline is centered and descenders
+//     hang below the middle; handy for all-caps text.
+//   - legacy: the baseline sits at two thirds of imgH regardless of the font.
+func baselineY(face font.Face, valign string, imgH int) (int, error) {
+	m := face.Metrics()
+	ascent, descent := m.Ascent.Round(), m.Descent.Round()
+	switch valign {
+	case "top":
+		return ascent, nil
+	case "center":
+		return (imgH + ascent - descent) / 2, nil
+	case "bottom":
+		return imgH - descent, nil
+	case "baseline":
+		return (imgH + ascent) / 2, nil
+	case "legacy", "":
+		return imgH * 2 / 3, nil
+	default:
+		return 0, checkVAlign(valign)
+	}
+}
+
+func checkVAlign(valign string) error {
+	switch valign {
+	case "top", "center", "bottom", "baseline", "legacy", "":
+		return nil
+	}
+	return configErrorf("unknown vertical alignment %q (want top, center, bottom, baseline or legacy)", valign)
+}
+
+// anchorOffset returns where to put the top left corner of a box of size
+// inner so that it sits at anchor within a box of size outer: at a corner
+// (nw, ne, sw, se), against the middle of a side (n, w, e, s) or in the
+// center.
+func anchorOffset(anchor string, outer, inner image.Point) (image.Point, error) {
+	free := outer.Sub(inner)
+	var fx, fy int // 0, 1 or 2 halves of the free space before the box
+	switch anchor {
+	case "nw":
+		fx, fy = 0, 0
+	case "n":
+		fx, fy = 1, 0
+	case "ne":
+		fx, fy = 2, 0
+	case "w":
+		fx, fy = 0, 1
+	case "center", "":
+		fx, fy = 1, 1
+	case "e":
+		fx, fy = 2, 1
+	case "sw":
+		fx, fy = 0, 2
+	case "s":
+		fx, fy = 1, 2
+	case "se":
+		fx, fy = 2, 2
+	default:
+		return image.Point{}, configErrorf("unknown anchor %q (want nw, n, ne, w, center, e, sw, s or se)", anchor)
+	}
+	return image.Pt(free.X*fx/2, free.Y*fy/2), nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// glyphCount returns the number of glyphs in the layout.
+func (tl textLayout) glyphCount() int {
+	n := 0
+	for _, line := range tl.lines {
+		n += len(line)
+	}
+	return n
+}
+
+// reveal returns a copy of tl that keeps only its first n glyphs, in
+// reading order. Line widths shrink to the glyphs kept so that rules grow
+// with the text.
+func (tl textLayout) reveal(n int) textLayout {
+	out := tl
+	out.lines = make([][]glyphPos, len(tl.lines))
+	out.widths = make([]int, len(tl.widths))
+	for row, line := range tl.lines {
+		k := len(line)
+		if k > n {
+			k = n
+		}
+		n -= k
+		out.lines[row] = line[:k]
+		if row < len(tl.widths) && k == len(line) {
+			out.widths[row] = tl.widths[row]
+		} else if row < len(tl.widths) && k > 0 {
+			g := line[k-1]
+			out.widths[row] = g.x + g.advance
+		}
+	}
+	return out
+}
+
+// expandTabs replaces each tab in lines with the spaces that take it to
+// the next multiple of width characters, styled like the tab.
+func expandTabs(lines []string, styles [][]runeStyle, width int) ([]string, [][]runeStyle) {
+	width = maxInt(width, 1)
+	out := make([]string, len(lines))
+	outStyles := make([][]runeStyle, len(lines))
+	for i, line := range lines {
+		if !strings.ContainsRune(line, '\t') {
+			out[i], outStyles[i] = line, styles[i]
+			continue
+		}
+		var sb strings.Builder
+		col := 0
+		for j, r := range []rune(line) {
+			if r == '\t' {
+				n := width - col%width
+				sb.WriteString(strings.Repeat(" ", n))
+				for k := 0; k < n; k++ {
+					outStyles[i] = append(outStyles[i], styles[i][j])
+				}
+				col += n
+				continue
+			}
+			sb.WriteRune(r)
+			outStyles[i] = append(outStyles[i], styles[i][j])
+			col++
+		}
+		out[i] = sb.String()
+	}
+	return out, outStyles
+}
+
+// gutterLen returns the number of runes at the start of a line that
+// numberLines added, given their styles.
+func gutterLen(styles []runeStyle) int {
+	n := 0
+	for n < len(styles) && styles[n].gutter {
+		n++
+	}
+	return n
+}
+
+// alignGutters shifts proportional lines that start with a line number so
+// that their text begins at the same x, after the widest number, which
+// ends up right-aligned against it. Slot mode needs no shift, as every
+// gutter takes the same slots.
+func alignGutters(lines [][]glyphPos, widths []int) {
+	starts := make([]int, len(lines))
+	end := 0
+	for i, line := range lines {
+		n := 0
+		for n < len(line) && line[n].gutter {
+			n++
+		}
+		if n == 0 {
+			starts[i] = -1
+			continue
+		}
+		// The gutter ends with a space, which is never shifted.
+		starts[i] = line[n-1].x + line[n-1].advance
+		end = maxInt(end, starts[i])
+	}
+	for i, line := range lines {
+		if starts[i] < 0 {
+			continue
+		}
+		d := end - starts[i]
+		for j := range line {
+			line[j].x += d
+		}
+		widths[i] += d
+	}
+}
+
+// numberLines prefixes each line with its number, counting from start,
+// right-aligned in a gutter as wide as the widest number and followed by a
+// space. The gutter runes are styled as such; the font and scripts of the
+// line are left to its text. For rtl lines, which mirrorLine reverses, the
+// digits come in reverse order so that they still read left to right.
+func numberLines(lines []string, styles [][]runeStyle, start int, rtl bool) ([]string, [][]runeStyle) {
+	digits := maxInt(len(strconv.Itoa(start)), len(strconv.Itoa(start+len(lines)-1)))
+	out := make([]string, len(lines))
+	outStyles := make([][]runeStyle, len(lines))
+	for i, line := range lines {
+		prefix := fmt.Sprintf("%*d ", digits, start+i)
+		if rtl {
+			n := []rune(strconv.Itoa(start + i))
+			for a, b := 0, len(n)-1; a < b; a, b = a+1, b-1 {
+				n[a], n[b] = n[b], n[a]
+			}
+			prefix = string(n) + strings.Repeat(" ", digits-len(n)) + " "
+		}
+		out[i] = prefix + line
+		for range prefix {
+			outStyles[i] = append(outStyles[i], runeStyle{font: -1, gutter: true})
+		}
+		outStyles[i] = append(outStyles[i], styles[i]...)
+	}
+	return out, outStyles
+}
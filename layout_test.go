@@ -0,0 +1,233 @@
+package txt2png
+
+import (
+	"image"
+	"math"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// testConfig returns settings for a small render of text in the embedded
+// font on a transparent background, so that ink is any pixel with alpha.
+func testConfig(text string) Config {
+	cfg := DefaultConfig()
+	cfg.Text, cfg.FontFile, cfg.Transparent = text, "", true
+	cfg.Size, cfg.SlotWidth, cfg.Height = 40, 40, 60
+	return cfg
+}
+
+// slotInk returns the ink of the part of img inside r, relative to r.Min,
+// as found by inkBounds on a transparent background.
+func slotInk(img *image.RGBA, r image.Rectangle) image.Rectangle {
+	return inkBounds(crop(img, r), nil, true)
+}
+
+func TestMultibyteRunesFillConsecutiveSlots(t *testing.T) {
+	cfg := testConfig("áéíóú")
+	img, err := RenderText(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds().Dx(), 5*cfg.SlotWidth; got != want {
+		t.Fatalf("width = %d, want %d", got, want)
+	}
+	for i := 0; i < 5; i++ {
+		slot := image.Rect(i*cfg.SlotWidth, 0, (i+1)*cfg.SlotWidth, img.Bounds().Dy())
+		if slotInk(img, slot).Empty() {
+			t.Errorf("slot %d has no ink", i)
+		}
+	}
+}
+
+func TestTrackingWidensProportionalText(t *testing.T) {
+	cfg := testConfig("Track")
+	cfg.Proportional = true
+	base, err := Measure(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len([]rune(cfg.Text))
+	for _, tracking := range []int{-3, 5, 12} {
+		cfg.Tracking = tracking
+		size, err := Measure(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := size.X-base.X, tracking*(n-1); got != want {
+			t.Errorf("tracking %d: width grew by %d, want %d", tracking, got, want)
+		}
+	}
+}
+
+// kernFace is a face that kerns the pair "AV" by kern.
+type kernFace struct {
+	font.Face
+	kern fixed.Int26_6
+}
+
+func (f kernFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if r0 == 'A' && r1 == 'V' {
+		return f.kern
+	}
+	return 0
+}
+
+func TestKerningNarrowsAV(t *testing.T) {
+	// The embedded font has no kerning, so lay out with a face that has.
+	f, err := loadFont("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := newFaceChain(fontSet{fonts: []*parsedFont{f}}, 72, 40, font.HintingNone)
+	faces.faces[0] = kernFace{faces.faces[0], fixed.I(-5)}
+	styles := plainStyles([]string{"AV"})[0]
+	o := layoutOpts{proportional: true, tabWidth: 4}
+	_, plain := layoutLine(faces, "AV", styles, o)
+	o.kern = true
+	_, kerned := layoutLine(faces, "AV", styles, o)
+	if kerned != plain-5 {
+		t.Errorf("width of AV is %d kerned and %d without, want 5 less kerned", kerned, plain)
+	}
+}
+
+func TestGlyphInkCenteredInSlot(t *testing.T) {
+	// These glyphs sit off center in their advance.
+	for _, r := range "jfr1" {
+		cfg := testConfig(string(r))
+		cfg.Size, cfg.SlotWidth, cfg.Height = 60, 80, 90
+		img, err := RenderText(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ink := slotInk(img, img.Bounds())
+		if ink.Empty() {
+			t.Fatalf("%q has no ink", r)
+		}
+		// Twice the ink center, to stay in integers.
+		if mid := ink.Min.X + ink.Max.X; mid < cfg.SlotWidth-2 || mid > cfg.SlotWidth+2 {
+			t.Errorf("ink of %q spans x %d to %d, not centered in the %dpx slot", r, ink.Min.X, ink.Max.X, cfg.SlotWidth)
+		}
+	}
+}
+
+func TestInkStaysInSlotAtSeveralSizes(t *testing.T) {
+	for _, hinting := range []string{"none", "full"} {
+		for _, size := range []float64{13, 20, 33, 47, 61} {
+			cfg := testConfig("MW@")
+			cfg.Hinting, cfg.Size = hinting, size
+			cfg.SlotWidth, cfg.Height = int(size), int(size*1.5)
+			img, err := RenderText(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				slot := image.Rect(i*cfg.SlotWidth, 0, (i+1)*cfg.SlotWidth, cfg.Height)
+				// Ink touching the slot edge may have come from further.
+				ink := slotInk(img, slot)
+				if ink.Empty() || ink.Min.X == 0 || ink.Max.X == cfg.SlotWidth {
+					t.Errorf("hinting %s, size %g: ink of slot %d spans x %d to %d of %d", hinting, size, i, ink.Min.X, ink.Max.X, cfg.SlotWidth)
+					continue
+				}
+				if mid := ink.Min.X + ink.Max.X; mid < cfg.SlotWidth-2 || mid > cfg.SlotWidth+2 {
+					t.Errorf("hinting %s, size %g: ink of slot %d spans x %d to %d, not centered", hinting, size, i, ink.Min.X, ink.Max.X)
+				}
+			}
+		}
+	}
+}
+
+func TestLineNumbersWithWrapAndRTL(t *testing.T) {
+	text := "a b c d\nb\nc\nd\ne\nf\ng\nh\ni\nj"
+	for _, rtl := range []bool{false, true} {
+		cfg := testConfig(text)
+		cfg.LineNumbers, cfg.Wrap, cfg.RTL, cfg.HAlign = true, 6*cfg.SlotWidth, rtl, "left"
+		p, err := layoutPage(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(p.tl.lines); n != 11 {
+			t.Fatalf("rtl %v: %d lines, want the first one wrapped into 2", rtl, n)
+		}
+		textX := -1
+		for i, line := range p.tl.lines {
+			// x of the first character of the text, on the right with rtl,
+			// and of the digits of the line number.
+			x := -1
+			digitX := make(map[rune]int)
+			for _, g := range line {
+				switch {
+				case g.gutter && isDigit(g.r):
+					digitX[g.r] = g.x
+				case !g.gutter && x < 0:
+					x = p.tl.origins[i].X + g.x
+				}
+			}
+			if textX < 0 {
+				textX = x
+			} else if x != textX {
+				t.Errorf("rtl %v: text of line %d starts at x %d, want %d like the first", rtl, i, x, textX)
+			}
+			if x1, ok := digitX['1']; ok && len(digitX) == 2 && x1 > digitX['0'] {
+				t.Errorf("rtl %v: line %d is numbered 01 from left to right", rtl, i)
+			}
+		}
+	}
+}
+
+func TestWideCellsOutsideSlotMode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		set  func(*Config)
+	}{
+		{"proportional", func(c *Config) { c.Proportional = true }},
+		{"justify", func(c *Config) { c.Justify, c.TotalWidth = true, 300 }},
+		{"slots", func(c *Config) {}},
+	} {
+		cfg := testConfig("日本a")
+		cfg.WideCells = true
+		tc.set(&cfg)
+		size, err := Measure(cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if tc.name == "slots" && size.X != 5*cfg.SlotWidth {
+			t.Errorf("slots: width %d, want 5 slots of %d", size.X, cfg.SlotWidth)
+		}
+	}
+}
+
+func TestSubpixelDrift(t *testing.T) {
+	f, err := loadFont("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	line := strings.Repeat("n", n)
+	styles := plainStyles([]string{line})[0]
+	// At 14.5pt an advance is about 8.7px: rounding each one adds 0.3px
+	// of drift per glyph, truncating would lose 0.7px.
+	for _, size := range []float64{14.5, 13.7, 21} {
+		faces := newFaceChain(fontSet{fonts: []*parsedFont{f}}, 72, size, font.HintingNone)
+		adv, _ := faces.primary().GlyphAdvance('n')
+		exact := float64(n) * float64(adv) / 64
+		o := layoutOpts{proportional: true, tabWidth: 4}
+		_, rounded := layoutLine(faces, line, styles, o)
+		o.subpixel = true
+		_, subpixel := layoutLine(faces, line, styles, o)
+
+		if want := n * adv.Round(); rounded != want {
+			t.Errorf("size %g: rounded width %d, want %d advances of %dpx", size, rounded, want, adv.Round())
+		}
+		if d := math.Abs(float64(adv.Round()) - float64(adv)/64); d > 0.5 {
+			t.Errorf("size %g: advance %v rounded to %d, off by %.2fpx", size, adv, adv.Round(), d)
+		}
+		if d := math.Abs(float64(subpixel) - exact); d > 1 {
+			t.Errorf("size %g: subpixel width %d drifts %.2fpx from %.2f", size, subpixel, d, exact)
+		}
+		if ds, dr := math.Abs(float64(subpixel)-exact), math.Abs(float64(rounded)-exact); dr > 1 && ds >= dr {
+			t.Errorf("size %g: subpixel drift %.2fpx is no better than rounded %.2fpx", size, ds, dr)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package txt2png
+
+import (
+	"fmt"
+	"strings"
+)
+
+// runeStyle is what markup asks of one rune of the text.
+type runeStyle struct {
+	font   int     // index in the face chain of the font to draw the rune with, or -1 for the usual chain
+	level  int     // nesting depth of sub- and superscripts around the rune
+	rise   float64 // baseline shift in ems of the text size; positive is up
+	gutter bool    // part of a line number added by Config.LineNumbers
+}
+
+// Script shifts relative to the size of the text a script is attached to.
+const (
+	superRise = 0.35
+	subDrop   = 0.2
+)
+
+// plainStyles returns the styles of lines without markup.
+func plainStyles(lines []string) [][]runeStyle {
+	styles := make([][]runeStyle, len(lines))
+	for i, line := range lines {
+		styles[i] = make([]runeStyle, len([]rune(line)))
+		for j := range styles[i] {
+			styles[i][j].font = -1
+		}
+	}
+	return styles
+}
+
+// scriptLevels returns the deepest nesting of sub- and superscripts in
+// styles.
+func scriptLevels(styles [][]runeStyle) int {
+	n := 0
+	for _, line := range styles {
+		for _, st := range line {
+			n = maxInt(n, st.level)
+		}
+	}
+	return n
+}
+
+// markupGroup is a part of the text opened by a tag and not yet closed.
+type markupGroup struct {
+	script bool // opened by _{ or ^{ rather than {f:...}
+	style  runeStyle
+}
+
+// parseMarkup removes the markup from lines:
+//
+//   - {f:path} draws what follows with the font file at path, until the
+//     matching {/f} returns to the font before it;
+//   - _{...} is a subscript and ^{...} a superscript, smaller than the
+//     text around them and lowered or raised from its baseline;
+//   - {{ stands for a literal {. A } ends the innermost script, so scripts
+//     cannot contain a literal }.
+//
+// Groups nest, and font switches may span lines but scripts may not. It
+// returns the plain lines, the style of each of their runes and the font
+// files switched to, each listed once; the style of a rune drawn with
+// paths[i] has font base+i.
+func parseMarkup(lines []string, base int) (plain []string, styles [][]runeStyle, paths []string, err error) {
+	index := make(map[string]int)
+	stack := []markupGroup{{style: runeStyle{font: -1}}}
+	for _, line := range lines {
+		var sb strings.Builder
+		var st []runeStyle
+		emit := func(r rune) {
+			sb.WriteRune(r)
+			st = append(st, stack[len(stack)-1].style)
+		}
+		for rest := line; rest != ""; {
+			top := stack[len(stack)-1]
+			switch {
+			case strings.HasPrefix(rest, "{{"):
+				emit('{')
+				rest = rest[2:]
+				continue
+			case top.script && rest[0] == '}':
+				stack = stack[:len(stack)-1]
+				rest = rest[1:]
+				continue
+			case (strings.HasPrefix(rest, "_{") || strings.HasPrefix(rest, "^{")) && !strings.HasPrefix(rest[1:], "{{"):
+				s := top.style
+				scale := 1.0
+				for i := 0; i < s.level; i++ {
+					scale *= scriptScale
+				}
+				if rest[0] == '^' {
+					s.rise += superRise * scale
+				} else {
+					s.rise -= subDrop * scale
+				}
+				s.level++
+				stack = append(stack, markupGroup{script: true, style: s})
+				rest = rest[2:]
+				continue
+			case rest[0] != '{':
+				r := []rune(rest)[0]
+				emit(r)
+				rest = rest[len(string(r)):]
+				continue
+			}
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, nil, nil, fmt.Errorf("markup: unterminated tag %q (write {{ for a literal {)", rest)
+			}
+			tag := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case strings.HasPrefix(tag, "f:"):
+				path := tag[2:]
+				if path == "" {
+					return nil, nil, nil, fmt.Errorf("markup: {f:} needs a font file")
+				}
+				i, ok := index[path]
+				if !ok {
+					i = base + len(paths)
+					index[path] = i
+					paths = append(paths, path)
+				}
+				s := top.style
+				s.font = i
+				stack = append(stack, markupGroup{style: s})
+			case tag == "/f":
+				if len(stack) == 1 || top.script {
+					return nil, nil, nil, fmt.Errorf("markup: {/f} without a matching {f:...}")
+				}
+				stack = stack[:len(stack)-1]
+			default:
+				return nil, nil, nil, fmt.Errorf("markup: unknown tag {%s}", tag)
+			}
+		}
+		for _, g := range stack {
+			if g.script {
+				return nil, nil, nil, fmt.Errorf("markup: sub- or superscript not closed by } at the end of a line")
+			}
+		}
+		plain = append(plain, sb.String())
+		styles = append(styles, st)
+	}
+	if len(stack) > 1 {
+		return nil, nil, nil, fmt.Errorf("markup: %d {f:...} not closed by {/f}", len(stack)-1)
+	}
+	return plain, styles, paths, nil
+}
@@ -0,0 +1,71 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+)
+
+// Lengths in pixels of the ticks of a ruler.
+const (
+	rulerTick      = 3
+	rulerLabelTick = 7
+)
+
+// drawRuler draws tick marks every step pixels along the top and left edges
+// of dst, in c, with a longer tick numbered with its distance from the
+// corner every every ticks. Values below 1 count as 1.
+func drawRuler(dst *image.RGBA, step, every int, c color.RGBA) error {
+	step, every = maxInt(step, 1), maxInt(every, 1)
+	b := dst.Bounds()
+	src := image.NewUniform(c)
+	labels := make(map[int]*image.RGBA)
+	label := func(v int) (*image.RGBA, error) {
+		if l, ok := labels[v]; ok {
+			return l, nil
+		}
+		l, err := rulerLabel(strconv.Itoa(v), c)
+		labels[v] = l
+		return l, err
+	}
+
+	for i, x := 0, 0; x < b.Dx(); i, x = i+1, x+step {
+		n := rulerTick
+		if i%every == 0 {
+			n = rulerLabelTick
+			l, err := label(x)
+			if err != nil {
+				return err
+			}
+			at := image.Pt(b.Min.X+x+2, b.Min.Y+1)
+			draw.Draw(dst, l.Bounds().Add(at), l, image.Point{}, draw.Over)
+		}
+		draw.Draw(dst, image.Rect(b.Min.X+x, b.Min.Y, b.Min.X+x+1, b.Min.Y+n), src, image.Point{}, draw.Over)
+	}
+	for i, y := 0, 0; y < b.Dy(); i, y = i+1, y+step {
+		n := rulerTick
+		if i%every == 0 && y > 0 {
+			n = rulerLabelTick
+			l, err := label(y)
+			if err != nil {
+				return err
+			}
+			at := image.Pt(b.Min.X+n+1, b.Min.Y+y-l.Bounds().Dy()/2)
+			draw.Draw(dst, l.Bounds().Add(at), l, image.Point{}, draw.Over)
+		}
+		draw.Draw(dst, image.Rect(b.Min.X, b.Min.Y+y, b.Min.X+n, b.Min.Y+y+1), src, image.Point{}, draw.Over)
+	}
+	return nil
+}
+
+// rulerLabel renders the number text of a ruler tick, small, in the
+// embedded font on a transparent background.
+func rulerLabel(text string, c color.RGBA) (*image.RGBA, error) {
+	l := DefaultConfig()
+	l.Text, l.FontFile, l.Pixels, l.Proportional, l.Transparent = text, "", true, true, true
+	l.Size, l.Height, l.VAlign = 9, 11, "top"
+	l.FG = fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	return RenderText(l)
+}
@@ -0,0 +1,116 @@
+package txt2png
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"unicode"
+)
+
+// GlyphSheet renders every printable character from `from` to `to`
+// inclusive in a grid of the given number of columns, each in its own cell
+// with its code point written under it, for auditing the coverage and
+// metrics of a font. Cells are rendered with the settings of cfg in slot
+// mode; Text and the settings that change the size of a single render
+// (fitting, wrapping, proportional and vertical layout, captions,
+// trimming, rotation and typewriter frames) are ignored. Scale, Border and Radius
+// apply to the whole sheet.
+func GlyphSheet(cfg Config, from, to rune, columns int) (*image.RGBA, error) {
+	if from > to {
+		return nil, fmt.Errorf("empty character range U+%04X-U+%04X", from, to)
+	}
+	if columns < 1 {
+		return nil, fmt.Errorf("glyph sheet needs at least one column, got %d", columns)
+	}
+	if cfg.Scale < 0 {
+		return nil, configErrorf("scale must not be negative, got %d", cfg.Scale)
+	}
+	fgc, bgc, _, err := cfg.Colors()
+	if err != nil {
+		return nil, err
+	}
+
+	c := cfg
+	c.Markup, c.Proportional, c.Vertical, c.AutoGrow = false, false, false, false
+	c.FitWidth, c.FitHeight, c.Wrap, c.TotalWidth = 0, 0, 0, 0
+	c.Trim, c.Typewriter, c.Rotate, c.Scale, c.Border, c.Radius = false, false, 0, 0, 0, 0
+	c.Caption, c.Ruler, c.Invert = "", false, false
+	c.Verbose = false // one metrics table per cell would drown everything else
+	c.Progress = nil
+	total := 0
+	for r := from; r <= to; r++ {
+		if unicode.IsPrint(r) {
+			total++
+		}
+	}
+
+	var cells, labels []*image.RGBA
+	cellW, cellH, labelH := 1, 1, 0
+	for r := from; r <= to; r++ {
+		if !unicode.IsPrint(r) {
+			continue
+		}
+		c.Text = string(r)
+		res, err := Render(c)
+		if err != nil {
+			return nil, fmt.Errorf("U+%04X: %w", r, err)
+		}
+		cells = append(cells, res.Image)
+		cellW, cellH = maxInt(cellW, res.Image.Bounds().Dx()), maxInt(cellH, res.Image.Bounds().Dy())
+		cfg.progress(len(cells), total)
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("no printable characters in U+%04X-U+%04X", from, to)
+	}
+
+	// Labels use the embedded font, sized to the cells, so that they stay
+	// readable whatever font is being inspected.
+	l := DefaultConfig()
+	l.FontFile, l.Pixels, l.Proportional, l.Transparent = "", true, true, true
+	l.Size = float64(maxInt(cellW/8, 8))
+	l.Height, l.VAlign = int(l.Size*3/2), "top"
+	l.FG = fmt.Sprintf("#%02x%02x%02x%02x", fgc.R, fgc.G, fgc.B, fgc.A)
+	for r := from; r <= to; r++ {
+		if !unicode.IsPrint(r) {
+			continue
+		}
+		l.Text = fmt.Sprintf("U+%04X", r)
+		res, err := Render(l)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, res.Image)
+		labelH = maxInt(labelH, res.Image.Bounds().Dy())
+	}
+
+	var bg image.Image = image.NewUniform(bgc)
+	if cfg.Transparent {
+		bg = image.Transparent
+	}
+	rows := (len(cells) + columns - 1) / columns
+	cols := minInt(columns, len(cells))
+	if err := checkPixels(image.Pt(cols*cellW, rows*(cellH+labelH)).Mul(maxInt(cfg.Scale, 1)), cfg.MaxPixels); err != nil {
+		return nil, err
+	}
+	sheet := createImage(cols*cellW, rows*(cellH+labelH), bg)
+	for i, cell := range cells {
+		at := image.Pt(i%columns*cellW, i/columns*(cellH+labelH))
+		draw.Draw(sheet, cell.Bounds().Add(at), cell, image.Point{}, draw.Src)
+		lb := labels[i].Bounds()
+		at = at.Add(image.Pt((cellW-lb.Dx())/2, cellH))
+		draw.Draw(sheet, lb.Add(at), labels[i], image.Point{}, draw.Over)
+	}
+	sheet = scale(sheet, cfg.Scale)
+	borderColor := fgc
+	if cfg.BorderColor != "" {
+		if borderColor, err = parseHexColor(cfg.BorderColor); err != nil {
+			return nil, fmt.Errorf("invalid border color: %w", err)
+		}
+	}
+	drawBorder(sheet, cfg.Border, borderColor)
+	roundCorners(sheet, cfg.Radius)
+	if cfg.Invert {
+		Invert(sheet)
+	}
+	return sheet, nil
+}
@@ -0,0 +1,29 @@
+package txt2png
+
+import "sort"
+
+// theme is a color preset for Config.Theme, in the syntax of Config.FG.
+type theme struct {
+	fg, bg string
+	ruler  string // guideline color; empty derives it from bg
+}
+
+// themes are the presets that Config.Theme can name. Adding an entry here
+// is all a new preset needs.
+var themes = map[string]theme{
+	"terminal":     {fg: "#33ff33", bg: "#000000", ruler: "#114411"},
+	"paper":        {fg: "#333333", bg: "#fdf6e3"},
+	"highcontrast": {fg: "#000000", bg: "#ffff00"},
+	"blueprint":    {fg: "#ffffff", bg: "#1f4e8c", ruler: "#5b86c0"},
+	"solarized":    {fg: "#839496", bg: "#002b36"},
+}
+
+// ThemeNames returns the names of the color presets, sorted.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,137 @@
+package txt2png
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// quarterTurns returns the clockwise rotation by angle degrees, which must
+// be a multiple of 90, in the range 0 to 270.
+func quarterTurns(angle int) (int, error) {
+	if angle%90 != 0 {
+		return 0, configErrorf("rotation must be 0, 90, 180 or 270 degrees, got %d", angle)
+	}
+	return ((angle % 360) + 360) % 360, nil
+}
+
+// rotate returns src rotated clockwise by angle degrees, which must be a
+// multiple of 90. Rotations by 90 and 270 swap the width and height.
+func rotate(src *image.RGBA, angle int) (*image.RGBA, error) {
+	deg, err := quarterTurns(angle)
+	if err != nil {
+		return nil, err
+	}
+	if deg == 0 {
+		return src, nil
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if deg != 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := src.RGBAAt(b.Min.X+x, b.Min.Y+y)
+			switch deg {
+			case 90:
+				dst.SetRGBA(h-1-y, x, c)
+			case 180:
+				dst.SetRGBA(w-1-x, h-1-y, c)
+			case 270:
+				dst.SetRGBA(y, w-1-x, c)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// inkBounds returns the smallest rectangle containing every pixel of img
+// that differs from the background: every pixel that isn't fully
+// transparent if transparent is set, else every pixel other than the one
+// bg has at the same place. It is empty if there is no ink.
+func inkBounds(img *image.RGBA, bg image.Image, transparent bool) image.Rectangle {
+	var r image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if (transparent && c.A == 0) || (!transparent && c == color.RGBAModel.Convert(bg.At(x, y))) {
+				continue
+			}
+			r = r.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	return r
+}
+
+// crop returns a copy of the part of src inside r, with its origin at 0,0.
+func crop(src *image.RGBA, r image.Rectangle) *image.RGBA {
+	r = r.Intersect(src.Bounds())
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, r.Min, draw.Src)
+	return dst
+}
+
+// Invert turns img into its negative in place, replacing every color by
+// its complement and keeping its opacity, so that black on white becomes
+// white on black and any other pair of colors flips the same way.
+// Transparent pixels stay transparent.
+func Invert(img *image.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p := img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()*4]
+		for i := 0; i < len(p); i += 4 {
+			// Colors are premultiplied, so the complement is taken
+			// against the alpha rather than 0xff.
+			a := p[i+3]
+			p[i], p[i+1], p[i+2] = a-p[i], a-p[i+1], a-p[i+2]
+		}
+	}
+}
+
+// Negative returns the complement of c, as Invert makes it.
+func Negative(c color.RGBA) color.RGBA {
+	return color.RGBA{c.A - c.R, c.A - c.G, c.A - c.B, c.A}
+}
+
+// tile returns a w x h image covered with copies of src, starting at the
+// top left corner, each row of copies shifted offset pixels further right
+// than the one above it.
+func tile(src *image.RGBA, w, h, offset int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for row, y := 0, 0; y < h; row, y = row+1, y+b.Dy() {
+		shift := (row*offset%b.Dx() + b.Dx()) % b.Dx()
+		for x := shift - b.Dx(); x < w; x += b.Dx() {
+			draw.Draw(dst, image.Rect(x, y, x+b.Dx(), y+b.Dy()), src, b.Min, draw.Src)
+		}
+	}
+	return dst
+}
+
+// scale enlarges src by the integer factor n, turning every pixel into an
+// n x n block.
+func scale(src *image.RGBA, n int) *image.RGBA {
+	if n <= 1 {
+		return src
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx()*n, b.Dy()*n))
+	for y := 0; y < b.Dy(); y++ {
+		row := dst.Pix[y*n*dst.Stride : y*n*dst.Stride+dst.Stride]
+		for x := 0; x < b.Dx(); x++ {
+			p := src.Pix[src.PixOffset(b.Min.X+x, b.Min.Y+y):][:4]
+			for i := 0; i < n; i++ {
+				copy(row[(x*n+i)*4:], p)
+			}
+		}
+		// The remaining rows of the block repeat the first.
+		for i := 1; i < n; i++ {
+			copy(dst.Pix[(y*n+i)*dst.Stride:], row)
+		}
+	}
+	return dst
+}
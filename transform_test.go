@@ -0,0 +1,58 @@
+package txt2png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRotateSwapsWidthAndHeight(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 7, 3))
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	src.SetRGBA(0, 0, red)
+	for _, tc := range []struct {
+		angle  int
+		size   image.Point
+		corner image.Point // where the top left pixel ends up
+	}{
+		{0, image.Pt(7, 3), image.Pt(0, 0)},
+		{90, image.Pt(3, 7), image.Pt(2, 0)},
+		{180, image.Pt(7, 3), image.Pt(6, 2)},
+		{270, image.Pt(3, 7), image.Pt(0, 6)},
+		{-90, image.Pt(3, 7), image.Pt(0, 6)},
+	} {
+		dst, err := rotate(src, tc.angle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := dst.Bounds().Size(); got != tc.size {
+			t.Errorf("rotate by %d: size %v, want %v", tc.angle, got, tc.size)
+		}
+		if got := dst.RGBAAt(tc.corner.X, tc.corner.Y); got != red {
+			t.Errorf("rotate by %d: pixel at %v is %v, want the top left one", tc.angle, tc.corner, got)
+		}
+	}
+	if _, err := rotate(src, 45); err == nil {
+		t.Error("rotate by 45 succeeded, want an error")
+	}
+}
+
+func TestScaleMultipliesSize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	src.SetRGBA(99, 49, blue)
+	dst := scale(src, 3)
+	if got, want := dst.Bounds().Size(), image.Pt(300, 150); got != want {
+		t.Fatalf("size %v, want %v", got, want)
+	}
+	for y := 147; y < 150; y++ {
+		for x := 297; x < 300; x++ {
+			if got := dst.RGBAAt(x, y); got != blue {
+				t.Errorf("pixel %d,%d is %v, want the bottom right one", x, y, got)
+			}
+		}
+	}
+	if got := dst.RGBAAt(296, 149); got != (color.RGBA{}) {
+		t.Errorf("pixel 296,149 is %v, want it left clear", got)
+	}
+}
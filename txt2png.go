@@ -1,169 +1,1209 @@
-// txt2png
-// Convert text to PNG image
-//
-// Usage:
-// txt2png -text "JOJO" -fontfile ./LiberationMono-Regular.ttf -dpi 72 -hinting none -size 125 -whiteonblack -out out.png -slotwidth 250 -height 200
+// Package txt2png renders text to an image, placing each character in a
+// fixed-width slot.
 //
 // Original code: https://github.com/chrplr/txt2png
 //
 // License: GPL-3.0
-
-package main
+package txt2png
 
 import (
-	"bufio"
-	"flag"
+	"encoding/hex"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
+	"io"
 	"log"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
 
-	"github.com/golang/freetype"
-	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font"
+	"golang.org/x/text/unicode/norm"
 )
 
-var (
-	dpi            = flag.Float64("dpi", 72, "screen resolution in Dots Per Inch")
-	fontfile       = flag.String("fontfile", "./LiberationMono-Regular.ttf", "filename of the ttf font")
-	hinting        = flag.String("hinting", "none", "none | full")
-	fontSize       = flag.Float64("size", 125, "font size in points")
-	wonb           = flag.Bool("whiteonblack", false, "white text on a black background")
-	text           = flag.String("text", "TEST", "text to render")
-	outFile        = flag.String("out", "out.png", "output PNG filename")
-	slotWidth      = flag.Int("slotwidth", 120, "width of each character slot in pixels")
-	imageHeight    = flag.Int("height", 120, "height of the image in pixels")
-	showGuidelines = flag.Bool("guidelines", false, "draw vertical guidelines between character slots")
-	verbose        = flag.Bool("verbose", false, "print informational messages to the console")
-)
+// Config holds the rendering settings. The zero value is not usable; start
+// from DefaultConfig and override the fields you need.
+type Config struct {
+	Text           string   // text to render; newlines start new rows
+	Caption        string   // smaller text centered below Text, in its own size and color
+	CaptionSize    float64  // caption size in the units of Size; 0 or less for 40% of the text size
+	CaptionColor   string   // caption color, same syntax as FG; defaults to a gray between the text and background colors
+	Markup         bool     // interpret {f:path}...{/f} font switches and _{...}, ^{...} scripts in Text; {{ is a literal {
+	AllowEmpty     bool     // render an empty Text as a blank slot instead of failing
+	Normalize      string   // Unicode normalization of Text before layout: NFC, NFD or none
+	FontFile       string   // path of the TrueType (.ttf) or OpenType (.otf) font; empty for the embedded font
+	Font           string   // name of an installed font to use instead of FontFile; see FindFont
+	Fallback       []string // font files tried in order for characters the main font lacks
+	MissingGlyph   string   // characters no font has: "skip", "box" (hollow placeholder) or "question" ('?')
+	DPI            float64  // screen resolution in dots per inch
+	Hinting        string   // "none", "vertical" or "full"
+	NoAntialias    bool     // draw glyphs with hard edges instead of antialiased
+	Bold           bool     // faux bold: draw each glyph again BoldStrength times, shifted one more pixel right each time
+	BoldStrength   int      // extra pixels of stroke width added by Bold
+	Italic         bool     // faux italic: shear the glyphs to the right by Slant
+	Slant          float64  // slant of Italic in degrees from the vertical; negative leans left
+	Size           float64  // font size in points
+	Pixels         bool     // Size is in pixels: DPI is taken as 72, where 1pt is 1px
+	FitWidth       int      // if positive, choose Size so the text fits a box this many pixels wide, and make the image that wide
+	FitHeight      int      // if positive, choose Size so the text fits a box this many pixels tall, and make the image that tall instead of Height
+	CapHeight      int      // if positive, choose Size so capital letters are exactly this many pixels tall
+	WhiteOnBlack   bool     // white text on a black background
+	Theme          string   // named color preset (see ThemeNames) giving FG, BG and the guideline color; FG and BG override it
+	FG             string   // text color as #rgb, #rrggbb or #rrggbbaa; overrides WhiteOnBlack
+	FGGradient     string   // text filled top to bottom with a gradient, as "#from -> #to"; overrides FG
+	CharColors     []string // colors cycled across the characters, same syntax as FG; empty uses FG for all
+	BG             string   // background color, same syntax as FG
+	BGGradient     string   // background gradient as "#from -> #to"; overrides BG
+	BGGradientDir  string   // direction of BGGradient: h (left to right), v (top to bottom) or diag
+	BGImage        string   // image file (PNG, JPEG or GIF) drawn over the background
+	BGFit          string   // how BGImage fills the canvas: stretch, tile or center
+	Watermark      string   // text drawn faintly along the diagonal, behind the main text
+	WatermarkAlpha float64  // opacity of Watermark, from 0 to 1
+	BGAlpha        int      // opacity of the background from 0 (clear) to 255 (opaque), applied on top of any alpha of BG
+	Transparent    bool     // leave the background fully transparent
+	SlotColors     []string // "index:color" pairs filling the background of slot index (from 0) in that color; slot mode only
+	SlotWidth      int      // width of each character slot in pixels
+	WideCells      bool     // give East Asian wide and fullwidth characters two slots, so mixed CJK and Latin text keeps a monospace grid
+	TotalWidth     int      // if positive, share this many pixels out over the slots instead of SlotWidth each
+	Justify        bool     // spread the characters of each line over TotalWidth, first at the left edge and last at the right, with equal gaps
+	Height         int      // height of the first row in pixels, or of the whole image when Width is set
+	Width          int      // if positive, make the image Width x Height and place the text block, rows as tall as the font's line, at Anchor
+	Anchor         string   // where Width places the text: nw, n, ne, w, center, e, sw, s or se
+	Repeat         bool     // tile the text block, laid out as for Width, over the whole Width x Height image instead of placing it once
+	RepeatOffset   int      // with Repeat, shift each row of tiles this many pixels right of the one above, for a brick pattern
+	AutoGrow       bool     // grow the image when glyphs would be clipped at the top or bottom
+	HAlign         string   // left, center or right alignment of glyphs within their slots
+	Align          string   // per-line alignment in the text block, comma-separated l, c or r, the last one holding for the remaining lines; default left (right with RTL)
+	VAlign         string   // top, center, bottom, baseline or legacy (baseline at 2/3 of Height)
+	Baseline       int      // if positive, the y in pixels of the first baseline, overriding VAlign
+	Vertical       bool     // stack characters top to bottom, one line per column
+	Proportional   bool     // lay glyphs out by their advance widths instead of in fixed slots
+	NoKern         bool     // ignore the font's kerning in proportional mode
+	TabularNums    bool     // in proportional mode, give every digit the advance of the widest one so numbers line up
+	Subpixel       bool     // in proportional mode, place glyphs at fractional pixel positions instead of rounding each advance
+	RTL            bool     // lay lines out right to left, aligned to the right edge; no shaping
+	Padding        int      // blank pixels around the text on every side
+	PadTop         int      // top padding in pixels; -1 means use Padding
+	PadBottom      int      // bottom padding in pixels; -1 means use Padding
+	PadLeft        int      // left padding in pixels; -1 means use Padding
+	PadRight       int      // right padding in pixels; -1 means use Padding
+	TabWidth       int      // tab stops every this many slots, or space widths in proportional mode
+	LineNumbers    bool     // number the lines in a gutter on their left, in a color between the guideline and text colors
+	StartLine      int      // number of the first line with LineNumbers
+	Tracking       int      // extra pixels between letters (may be negative); widens or narrows slots in slot mode
+	Wrap           int      // if positive, wrap lines at spaces so that none is wider than this many pixels
+	LineSpacing    float64  // line height as a multiple of the font's line height; must be positive
+	Guidelines     bool     // draw vertical guidelines between slots
+	BaselineGuide  bool     // draw a horizontal guideline at every baseline
+	GuidelineColor string   // guideline color, same syntax as FG; defaults to a shade of the background
+	GuidelineEvery int      // draw a guideline every this many slots; below 1 means every slot
+	GuidelineWidth int      // guideline width in pixels
+	GuidesOnTop    bool     // draw the guidelines half transparent over the text instead of under it
+	Ruler          bool     // draw a pixel ruler along the top and left edges, in the guideline color
+	RulerStep      int      // pixels between ruler ticks
+	RulerLabels    int      // number every this many ruler ticks
+	Underline      bool     // underline each line of text
+	Strikethrough  bool     // strike through the middle of the x-height of each line
+	Shadow         bool     // draw a semi-transparent drop shadow behind the text
+	ShadowDX       int      // horizontal shadow offset in pixels
+	ShadowDY       int      // vertical shadow offset in pixels
+	Glow           bool     // draw a soft halo of blurred glyphs behind the text, for a neon look
+	GlowRadius     int      // how far the glow spreads, in pixels
+	GlowColor      string   // glow color, same syntax as FG; defaults to the text color
+	Outline        bool     // draw a contrasting outline around each glyph
+	OutlineWidth   int      // outline width in pixels
+	OutlineColor   string   // outline color, same syntax as FG; defaults to black or white, whichever contrasts with the text
+	Trim           bool     // crop the image to the ink, keeping the padding around it
+	Border         int      // width in pixels of a frame drawn inside the edges of the finished image
+	BorderColor    string   // frame color, same syntax as FG; defaults to the text color
+	Radius         int      // round the corners of the finished image to this radius in pixels, leaving them transparent
+	Invert         bool     // turn the finished image into its negative, complementing every color
+	Typewriter     bool     // also render Result.Frames, revealing one character per frame
+	Rotate         int      // clockwise rotation of the finished image: 0, 90, 180 or 270
+	Scale          int      // enlarge the finished image by this integer factor, nearest neighbor; 0 or 1 keeps it
+	MaxPixels      int      // refuse to render images of more than this many pixels, guarding against runaway sizes; 0 or less for no limit
+	Strict         bool     // fail instead of warning when a character has no glyph or the text is clipped
+	Verbose        bool     // print informational messages to standard error
 
-func main() {
-	flag.Parse()
+	// Progress, if set, is called as Render paints the frames of a
+	// Typewriter animation and as GlyphSheet renders its cells, with the
+	// number done so far and the total, so that callers can report on long
+	// jobs. It has no JSON form.
+	Progress func(done, total int) `json:"-"`
+}
 
-	f := loadFont(*fontfile, *verbose)
+// DefaultConfig returns the settings used by the txt2png command when no
+// flags are given.
+func DefaultConfig() Config {
+	return Config{
+		Text:           "TEST",
+		FontFile:       defaultFontFile,
+		DPI:            72,
+		Hinting:        "none",
+		Size:           125,
+		SlotWidth:      120,
+		Height:         120,
+		Anchor:         "center",
+		HAlign:         "center",
+		VAlign:         "legacy",
+		MissingGlyph:   "skip",
+		PadTop:         -1,
+		PadBottom:      -1,
+		PadLeft:        -1,
+		PadRight:       -1,
+		LineSpacing:    1.2,
+		ShadowDX:       4,
+		ShadowDY:       4,
+		OutlineWidth:   2,
+		GlowRadius:     6,
+		BGGradientDir:  "h",
+		BGFit:          "stretch",
+		WatermarkAlpha: 0.15,
+		BGAlpha:        0xff,
+		TabWidth:       4,
+		Normalize:      "NFC",
+		GuidelineEvery: 1,
+		GuidelineWidth: 1,
+		RulerStep:      10,
+		RulerLabels:    5,
+		BoldStrength:   1,
+		Slant:          12,
+		StartLine:      1,
+		MaxPixels:      100_000_000,
+	}
+}
 
-	fg, bg, rulerColor := getColors(*wonb)
+// Result is a rendered image together with facts about how it was laid out.
+type Result struct {
+	Image *image.RGBA
+	Size  float64 // font size used, in points; differs from Config.Size when fitting
 
-	rgba := createImage(len(*text), *slotWidth, *imageHeight, bg, rulerColor, *showGuidelines)
+	// Baseline is the y in Image of the baseline of the first line, or -1
+	// when Rotate turns the text on its side.
+	Baseline int
 
-	c := getFreeTypeContext(f, *dpi, *fontSize, rgba, fg, *hinting)
+	// Frames is set when Config.Typewriter is: frame i shows the first i
+	// characters, and the last frame is Image.
+	Frames []*image.RGBA
+}
 
-	renderText(c, f, *text, *slotWidth, *imageHeight, *dpi, *fontSize, *verbose)
+// RenderText renders cfg.Text and returns the resulting image.
+func RenderText(cfg Config) (*image.RGBA, error) {
+	res, err := Render(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return res.Image, nil
+}
 
-	saveImage(*outFile, rgba)
+// Render renders cfg.Text like RenderText and also reports layout details.
+func Render(cfg Config) (*Result, error) {
+	p, err := layoutPage(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	tl, width, height, pad := p.tl, p.width, p.height, p.pad
+	f, face := p.faces.fonts[0], p.faces.primary()
 
-	if *verbose {
-		fmt.Printf("Successfully wrote %s\n", *outFile)
+	fgc, bgc, rulerColor, err := cfg.Colors()
+	if err != nil {
+		return nil, err
+	}
+	fgUniform := image.NewUniform(fgc)
+	var bg image.Image = image.NewUniform(bgc)
+	var charSrcs []image.Image
+	for _, hex := range cfg.CharColors {
+		c, err := parseHexColor(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid character color: %w", err)
+		}
+		charSrcs = append(charSrcs, image.NewUniform(c))
+	}
+	var ruler image.Image = image.NewUniform(rulerColor)
+	// Line numbers sit halfway between the guidelines and the text, so they
+	// read as secondary to it.
+	gutter := image.NewUniform(color.RGBA{
+		uint8((int(rulerColor.R) + int(fgc.R)) / 2),
+		uint8((int(rulerColor.G) + int(fgc.G)) / 2),
+		uint8((int(rulerColor.B) + int(fgc.B)) / 2),
+		uint8((int(rulerColor.A) + int(fgc.A)) / 2),
+	})
+	if cfg.Transparent {
+		// Only the glyph ink is opaque; guidelines are kept faint so they
+		// don't dominate whatever the image is overlaid on.
+		bg = image.Transparent
+		ruler = image.NewUniform(color.NRGBA{rulerColor.R, rulerColor.G, rulerColor.B, 0x60})
 	}
-}
 
-func loadFont(path string, verb bool) *truetype.Font {
-	if verb {
-		fmt.Printf("Loading fontfile %q\n", path)
+	if cfg.BGGradient != "" && !cfg.Transparent {
+		g, err := parseGradient(cfg.BGGradient, cfg.BGGradientDir, image.Rect(0, 0, width, height))
+		if err != nil {
+			return nil, fmt.Errorf("invalid background gradient: %w", err)
+		}
+		bg, ruler = g, g.rulers()
 	}
-	fontBytes, err := os.ReadFile(path)
+	if cfg.BGImage != "" {
+		if err := checkBGFit(cfg.BGFit); err != nil {
+			return nil, err
+		}
+		if bg, err = backgroundImage(cfg.BGImage, cfg.BGFit, width, height, bg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Watermark != "" {
+		canvas := createImage(width, height, bg)
+		if err := drawWatermark(canvas, cfg.Watermark, cfg.WatermarkAlpha, cfg); err != nil {
+			return nil, err
+		}
+		bg = canvas
+	}
+	if cfg.BGAlpha < 0xff && !cfg.Transparent {
+		// The whole background fades, gradient, image and watermark
+		// included; what is drawn on it later keeps its own opacity.
+		canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.DrawMask(canvas, canvas.Bounds(), bg, image.Point{}, image.NewUniform(color.Alpha{uint8(cfg.BGAlpha)}), image.Point{}, draw.Src)
+		bg = canvas
+	}
+	if cfg.GuidelineColor != "" {
+		c, err := parseHexColor(cfg.GuidelineColor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid guideline color: %w", err)
+		}
+		ruler = image.NewUniform(c)
+	}
+
+	slotBGs, err := parseSlotColors(cfg.SlotColors)
 	if err != nil {
-		log.Fatalf("Error reading font file: %v", err)
+		return nil, err
+	}
+	if len(slotBGs) > 0 && cfg.Proportional && !cfg.Vertical {
+		return nil, configErrorf("slot colors need slot mode, not proportional layout")
+	}
+
+	var fg image.Image = fgUniform
+	if cfg.FGGradient != "" {
+		g, err := parseGradient(cfg.FGGradient, "v", image.Rectangle{})
+		if err != nil {
+			return nil, fmt.Errorf("invalid text gradient: %w", err)
+		}
+		m := face.Metrics()
+		fg = &bandGradient{*g, textBands(tl, m.Ascent.Round(), m.Descent.Round())}
+	}
+
+	// Glyphs are drawn with the faces they were measured with, so that
+	// hinting cannot make the drawn advances differ from the layout.
+	drawFaces := p.faces
+	if cfg.NoAntialias {
+		drawFaces = drawFaces.aliased()
+	}
+	pxPerEm := cfg.Size * cfg.DPI / 72
+	pos, thickness := underlineMetrics(f, pxPerEm)
+	oc := contrastingColor(fgc)
+	if cfg.OutlineColor != "" {
+		if oc, err = parseHexColor(cfg.OutlineColor); err != nil {
+			return nil, fmt.Errorf("invalid outline color: %w", err)
+		}
 	}
-	f, err := truetype.Parse(fontBytes)
+	glowColor := fgc
+	if cfg.GlowColor != "" {
+		if glowColor, err = parseHexColor(cfg.GlowColor); err != nil {
+			return nil, fmt.Errorf("invalid glow color: %w", err)
+		}
+	}
+	var caption *image.RGBA
+	if cfg.Caption != "" {
+		cc, err := captionColor(cfg, fgc, bgc)
+		if err != nil {
+			return nil, err
+		}
+		if caption, err = RenderText(captionConfig(cfg, cc)); err != nil {
+			return nil, fmt.Errorf("caption: %w", err)
+		}
+	}
+
+	// paint draws tl onto a fresh canvas; typewriter frames call it with
+	// partial layouts so that every frame has the same size.
+	paint := func(tl textLayout) *image.RGBA {
+		rgba := createImage(width, height, bg)
+		for i, r := range tl.slotRects(rgba.Bounds()) {
+			if c, ok := slotBGs[i]; ok {
+				draw.Draw(rgba, r, image.NewUniform(c), image.Point{}, draw.Over)
+			}
+		}
+		guides := func() {
+			if cfg.Guidelines {
+				drawGuidelines(rgba, tl, ruler, cfg.GuidelineEvery, cfg.GuidelineWidth, cfg.GuidesOnTop)
+			}
+			if cfg.BaselineGuide {
+				drawBaselines(rgba, tl, ruler, cfg.GuidelineWidth, cfg.GuidesOnTop)
+			}
+		}
+		if !cfg.GuidesOnTop {
+			guides()
+		}
+
+		dr := newDrawer(rgba, fg, drawFaces.primary())
+
+		if cfg.Glow && cfg.GlowRadius > 0 {
+			drawGlow(rgba, drawFaces, tl, cfg.GlowRadius, glowColor)
+		}
+
+		if cfg.Shadow {
+			// The shadow keeps its own alpha, so on a transparent background
+			// it stays semi-transparent.
+			drawGlyphs(dr, drawFaces, tl, image.NewUniform(shadowColor), image.Pt(cfg.ShadowDX, cfg.ShadowDY))
+			dr.Src = fg
+		}
+
+		if cfg.Outline && cfg.OutlineWidth > 0 {
+			// Every glyph's outline goes down before any fill so that the
+			// outline of one letter never covers its neighbour.
+			for _, d := range diskOffsets(cfg.OutlineWidth) {
+				drawGlyphs(dr, drawFaces, tl, image.NewUniform(oc), d)
+			}
+			dr.Src = fg
+		}
+
+		bold := 0
+		if cfg.Bold {
+			bold = cfg.BoldStrength
+		}
+		renderText(dr, drawFaces, tl, charSrcs, gutter, bold)
+		drawMissingBoxes(rgba, tl, face, fg)
+
+		// Rules follow horizontal lines, so they don't apply to columns.
+		if cfg.Underline && !tl.vertical {
+			drawRules(rgba, tl, pos, thickness, fg)
+		}
+		if cfg.Strikethrough && !tl.vertical {
+			drawRules(rgba, tl, -xHeight(f, face, pxPerEm)/2-thickness/2, thickness, fg)
+		}
+		if caption != nil {
+			draw.Draw(rgba, tl.caption, caption, image.Point{}, draw.Over)
+		}
+		if cfg.GuidesOnTop {
+			guides()
+		}
+		return rgba
+	}
+
+	if err := cfg.checkMissing(tl); err != nil {
+		return nil, err
+	}
+	if cfg.Verbose {
+		printMetrics(os.Stderr, p.faces, tl, image.Rect(0, 0, width, height), cfg.Proportional && !cfg.Vertical)
+	}
+	rgba := paint(tl)
+	var frames []*image.RGBA
+	if cfg.Typewriter {
+		total := tl.glyphCount() + 1
+		for n := 0; n < total-1; n++ {
+			frames = append(frames, paint(tl.reveal(n)))
+			cfg.progress(n+1, total)
+		}
+		frames = append(frames, rgba)
+		cfg.progress(total, total)
+	}
+
+	// The trim box comes from the full text so that every typewriter frame
+	// keeps the size of the final image.
+	trim := rgba.Bounds()
+	if cfg.Trim {
+		if ink := inkBounds(rgba, bg, cfg.Transparent && cfg.BGImage == ""); !ink.Empty() {
+			trim = image.Rectangle{ink.Min.Sub(pad.Min), ink.Max.Add(pad.Max)}.Intersect(trim)
+			if cfg.Verbose {
+				before := rgba.Bounds().Size()
+				fmt.Fprintf(os.Stderr, "Trimmed %dx%d to %dx%d\n", before.X, before.Y, trim.Dx(), trim.Dy())
+			}
+		}
+	}
+	tickColor := rulerColor
+	if cfg.GuidelineColor != "" {
+		// Already checked along with the guidelines.
+		tickColor, _ = parseHexColor(cfg.GuidelineColor)
+	}
+	borderColor := fgc
+	if cfg.BorderColor != "" {
+		if borderColor, err = parseHexColor(cfg.BorderColor); err != nil {
+			return nil, fmt.Errorf("invalid border color: %w", err)
+		}
+	}
+	finish := func(m *image.RGBA) (*image.RGBA, error) {
+		if cfg.Trim {
+			m = crop(m, trim)
+		}
+		if cfg.Repeat {
+			m = tile(m, cfg.Width, cfg.Height, cfg.RepeatOffset)
+		}
+		if cfg.Ruler {
+			if err := drawRuler(m, cfg.RulerStep, cfg.RulerLabels, tickColor); err != nil {
+				return nil, err
+			}
+		}
+		m, err := rotate(m, cfg.Rotate)
+		if err != nil {
+			return nil, err
+		}
+		m = scale(m, cfg.Scale)
+		drawBorder(m, cfg.Border, borderColor)
+		roundCorners(m, cfg.Radius)
+		if cfg.Invert {
+			Invert(m)
+		}
+		return m, nil
+	}
+	if rgba, err = finish(rgba); err != nil {
+		return nil, err
+	}
+	for i := range frames {
+		if frames[i], err = finish(frames[i]); err != nil {
+			return nil, err
+		}
+	}
+	baseline := tl.origins[0].Y - trim.Min.Y
+	switch deg, _ := quarterTurns(cfg.Rotate); deg {
+	case 90, 270:
+		baseline = -1
+	case 180:
+		baseline = trim.Dy() - baseline
+	}
+	if baseline >= 0 && cfg.Scale > 1 {
+		baseline *= cfg.Scale
+	}
+	return &Result{Image: rgba, Frames: frames, Size: cfg.Size, Baseline: baseline}, nil
+}
+
+// Measure returns the size of the image that Render would produce for cfg,
+// without drawing it. Trim is not taken into account, since what it
+// removes depends on the drawn pixels.
+func Measure(cfg Config) (image.Point, error) {
+	p, err := layoutPage(&cfg)
 	if err != nil {
-		log.Fatalf("Error parsing font: %v", err)
+		return image.Point{}, err
+	}
+	size := image.Pt(p.width, p.height)
+	if cfg.Repeat {
+		size = image.Pt(cfg.Width, cfg.Height)
+	}
+	deg, err := quarterTurns(cfg.Rotate)
+	if err != nil {
+		return image.Point{}, err
+	}
+	if deg == 90 || deg == 270 {
+		size.X, size.Y = size.Y, size.X
 	}
-	return f
+	if cfg.Scale > 1 {
+		size = size.Mul(cfg.Scale)
+	}
+	return size, nil
 }
 
-func getColors(whiteOnBlack bool) (fg, bg image.Image, ruler color.Color) {
-	fg, bg = image.Image(image.Black), image.Image(image.White)
-	ruler = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
-	if whiteOnBlack {
-		fg, bg = image.White, image.Black
-		ruler = color.RGBA{0x44, 0x44, 0x44, 0xff}
+// page is the text laid out on a canvas, before anything is drawn.
+type page struct {
+	faces  faceChain
+	tl     textLayout
+	width  int
+	height int
+	pad    image.Rectangle
+}
+
+// Validate checks the settings of cfg that are wrong whatever the text and
+// fonts: sizes out of range, unknown names such as an alignment or theme,
+// and colors that don't parse. The error is a *ConfigError, so that
+// callers can reject the settings before rendering anything. Render and
+// Measure call it first.
+func (cfg Config) Validate() error {
+	if cfg.Scale < 0 {
+		return configErrorf("scale must not be negative, got %d", cfg.Scale)
 	}
-	return
+	if cfg.Bold && cfg.BoldStrength < 1 {
+		return configErrorf("bold strength must be at least 1, got %d", cfg.BoldStrength)
+	}
+	if cfg.Italic && (cfg.Slant <= -45 || cfg.Slant >= 45) {
+		return configErrorf("slant must be between -45 and 45 degrees, got %g", cfg.Slant)
+	}
+	// The size and DPI come before the height, which a caption derives
+	// from them.
+	if cfg.DPI <= 0 && !cfg.Pixels {
+		return configErrorf("DPI must be positive, got %g", cfg.DPI)
+	}
+	if cfg.Size <= 0 && cfg.FitWidth <= 0 && cfg.FitHeight <= 0 && cfg.CapHeight <= 0 {
+		return configErrorf("font size must be positive, got %g", cfg.Size)
+	}
+	if cfg.TabWidth < 1 {
+		return configErrorf("tab width must be at least 1, got %d", cfg.TabWidth)
+	}
+	if cfg.Height < 1 {
+		return configErrorf("height must be at least 1 pixel, got %d", cfg.Height)
+	}
+	if cfg.Padding < 0 {
+		return configErrorf("padding must not be negative, got %d", cfg.Padding)
+	}
+	for _, side := range []struct {
+		name string
+		v    int
+	}{{"top", cfg.PadTop}, {"bottom", cfg.PadBottom}, {"left", cfg.PadLeft}, {"right", cfg.PadRight}} {
+		if side.v < -1 {
+			return configErrorf("%s padding must not be negative (-1 uses the padding), got %d", side.name, side.v)
+		}
+	}
+	if cfg.LineSpacing <= 0 {
+		return configErrorf("line spacing must be positive, got %g", cfg.LineSpacing)
+	}
+	if cfg.SlotWidth < 1 && (!cfg.Proportional || cfg.Vertical) {
+		return configErrorf("slot width must be at least 1 pixel, got %d", cfg.SlotWidth)
+	}
+	if cfg.Justify && cfg.TotalWidth <= 0 {
+		return configErrorf("justify needs a positive total width, got %d", cfg.TotalWidth)
+	}
+	if cfg.Repeat && cfg.Width <= 0 {
+		return configErrorf("repeat needs a positive width, got %d", cfg.Width)
+	}
+	if cfg.CapHeight > 0 && (cfg.FitWidth > 0 || cfg.FitHeight > 0) {
+		return configErrorf("cap height cannot be combined with fitting the text")
+	}
+	if cfg.Watermark != "" && (cfg.WatermarkAlpha < 0 || cfg.WatermarkAlpha > 1) {
+		return configErrorf("watermark alpha must be between 0 and 1, got %g", cfg.WatermarkAlpha)
+	}
+	if cfg.BGAlpha < 0 || cfg.BGAlpha > 0xff {
+		return configErrorf("background alpha must be between 0 and 255, got %d", cfg.BGAlpha)
+	}
+	if cfg.Border < 0 {
+		return configErrorf("border width must not be negative, got %d", cfg.Border)
+	}
+	if cfg.Radius < 0 {
+		return configErrorf("corner radius must not be negative, got %d", cfg.Radius)
+	}
+
+	if _, err := parseHinting(cfg.Hinting); err != nil {
+		return err
+	}
+	if _, err := normalize("", cfg.Normalize); err != nil {
+		return err
+	}
+	if err := checkHAlign(cfg.HAlign); err != nil {
+		return err
+	}
+	if _, err := parseAlign(cfg.Align); err != nil {
+		return err
+	}
+	if err := checkVAlign(cfg.VAlign); err != nil {
+		return err
+	}
+	if _, err := anchorOffset(cfg.Anchor, image.Point{}, image.Point{}); err != nil {
+		return err
+	}
+	if err := checkMissingGlyph(cfg.MissingGlyph); err != nil {
+		return err
+	}
+	if _, err := quarterTurns(cfg.Rotate); err != nil {
+		return err
+	}
+	if cfg.BGImage != "" {
+		if err := checkBGFit(cfg.BGFit); err != nil {
+			return err
+		}
+	}
+	if cfg.BGGradient != "" {
+		if _, err := parseGradient(cfg.BGGradient, cfg.BGGradientDir, image.Rectangle{}); err != nil {
+			return fmt.Errorf("invalid background gradient: %w", err)
+		}
+	}
+	_, _, _, err := cfg.Colors()
+	return err
 }
 
-func createImage(textLen, slotW, imgH int, bg image.Image, rulerColor color.Color, showGuidelines bool) *image.RGBA {
-	width := textLen * slotW
-	if width == 0 {
-		width = slotW
+// layoutPage checks cfg, loads its fonts and lays its text out. It
+// updates cfg.DPI and cfg.Size when Pixels or fitting change them.
+func layoutPage(cfg *Config) (*page, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Text == "" && !cfg.AllowEmpty {
+		return nil, fmt.Errorf("text is empty")
+	}
+	fonts, err := cfg.loadFonts()
+	if err != nil {
+		return nil, err
 	}
-	rgba := image.NewRGBA(image.Rect(0, 0, width, imgH))
-	draw.Draw(rgba, rgba.Bounds(), bg, image.Point{}, draw.Src)
 
-	// Vertical guidelines
-	if showGuidelines {
-		for i := 0; i < textLen; i++ {
-			x := i * slotW
-			for y := 0; y < imgH; y++ {
-				rgba.Set(x, y, rulerColor)
+	if cfg.Pixels {
+		cfg.DPI = 72
+	}
+	text, err := normalize(cfg.Text, cfg.Normalize)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(text)
+	styles := plainStyles(lines)
+	set := fontSet{fonts: fonts}
+	if cfg.Markup {
+		var paths []string
+		if lines, styles, paths, err = parseMarkup(lines, len(fonts)); err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			mf, err := loadFont(path, cfg.Verbose)
+			if err != nil {
+				return nil, fmt.Errorf("markup font: %w", err)
 			}
+			set.fonts = append(set.fonts, mf)
 		}
+		set.extra = len(paths)
+		set.levels = scriptLevels(styles)
 	}
-	return rgba
+	if !cfg.Proportional {
+		// In slot mode a tab is the spaces up to the next tab stop.
+		lines, styles = expandTabs(lines, styles, cfg.TabWidth)
+	}
+	if cfg.LineNumbers {
+		lines, styles = numberLines(lines, styles, cfg.StartLine, cfg.RTL && !cfg.Vertical)
+	}
+	hinting, err := parseHinting(cfg.Hinting)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CapHeight > 0 {
+		if cfg.Size, err = capHeightSize(fonts[0], cfg.DPI, cfg.CapHeight, cfg.MaxPixels); err != nil {
+			return nil, err
+		}
+		if cfg.Verbose {
+			fmt.Fprintf(os.Stderr, "Font size for a %dpx cap height: %.2fpt\n", cfg.CapHeight, cfg.Size)
+		}
+	}
+	slotW := cfg.SlotWidth
+	if !cfg.Proportional && !cfg.Vertical {
+		slotW = maxInt(slotW+cfg.Tracking, 1)
+	}
+	fit := cfg.FitWidth > 0 || cfg.FitHeight > 0
+	if fit {
+		if cfg.FitWidth > 0 && !cfg.Proportional && !cfg.Vertical {
+			// The slots share the box out between them.
+			cfg.TotalWidth = cfg.FitWidth
+		}
+		cfg.Size = fitSize(set, hinting, lines, styles, *cfg, slotW)
+		if cfg.Verbose {
+			fmt.Fprintf(os.Stderr, "Fitted font size: %.2fpt\n", cfg.Size)
+		}
+	}
+	// Glyphs are rasterised whole, so a huge size must fail before that
+	// as a huge canvas does.
+	if err := checkEm(cfg.Size*cfg.DPI/72, cfg.MaxPixels); err != nil {
+		return nil, err
+	}
+	faces := newFaceChain(set, cfg.DPI, cfg.Size, hinting)
+	if cfg.Italic {
+		faces = faces.sheared(cfg.Slant)
+	}
+	face := faces.primary()
+	lineH := lineHeight(face, cfg.LineSpacing)
+	if fit && !cfg.Vertical {
+		// The image is as tall as the box, and at least as tall as the
+		// fitted text, which sits in its middle.
+		m := face.Metrics()
+		textH := m.Ascent.Ceil() + m.Descent.Ceil()
+		if cfg.FitHeight > 0 {
+			cfg.Height = maxInt(cfg.FitHeight-(len(lines)-1)*lineH, textH)
+		} else {
+			cfg.Height = maxInt(cfg.Height, textH)
+		}
+		if cfg.VAlign == "legacy" || cfg.VAlign == "" {
+			cfg.VAlign = "center"
+		}
+	}
+	baseline, err := baselineY(face, cfg.VAlign, cfg.Height)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Baseline > 0 {
+		baseline = cfg.Baseline
+	}
+	blockCfg := *cfg
+	if cfg.Width > 0 {
+		// On a fixed canvas the text block is only as tall as its lines;
+		// the anchor places it.
+		m := face.Metrics()
+		blockCfg.Height = m.Ascent.Round() + m.Descent.Round()
+		baseline, _ = baselineY(face, "top", blockCfg.Height)
+	}
+
+	tl, width, height := buildLayout(faces, lines, styles, blockCfg, slotW, baseline, lineH)
+	if fit && cfg.FitWidth > width && !cfg.Vertical {
+		// Proportional text narrower than the box is centered in it.
+		tl.translate(image.Pt((cfg.FitWidth-width)/2, 0))
+		width = cfg.FitWidth
+	}
+	if cfg.Caption != "" {
+		cs, err := captionSize(*cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cs.X > width {
+			tl.translate(image.Pt((cs.X-width)/2, 0))
+			width = cs.X
+		}
+		tl.caption = image.Rect(0, 0, cs.X, cs.Y).Add(image.Pt((width-cs.X)/2, height))
+		height += cs.Y
+	}
+	pad := cfg.padding()
+	tl.translate(pad.Min)
+	width += pad.Min.X + pad.Max.X
+	height += pad.Min.Y + pad.Max.Y
+	// A repeated block is drawn as a tile the size of the text and only
+	// fills the canvas when the image is finished.
+	canvas := cfg.Width > 0 && !cfg.Repeat
+	if canvas {
+		at, err := anchorOffset(cfg.Anchor, image.Pt(cfg.Width, cfg.Height), image.Pt(width, height))
+		if err != nil {
+			return nil, err
+		}
+		tl.translate(at)
+		width, height = cfg.Width, cfg.Height
+	}
+
+	ink, inked := tl.inkRect(faces, set.levels)
+	if cfg.Italic && inked && !canvas && (ink.Min.X < 0 || ink.Max.X > width) {
+		// The slant pushes the tops of glyphs past the edges of the text.
+		left := maxInt(-ink.Min.X, 0)
+		tl.translate(image.Pt(left, 0))
+		width += left + maxInt(ink.Max.X-width, 0)
+	}
+	if top, bottom := ink.Min.Y, ink.Max.Y; inked && (top < 0 || bottom > height) {
+		over, under := maxInt(-top, 0), maxInt(bottom-height, 0)
+		growTop, growBottom := over, under
+		switch {
+		case canvas:
+			growTop, growBottom = 0, 0
+		case !cfg.AutoGrow:
+			// Sub- and superscripts always get room, but the text they
+			// are attached to stays clipped as it would without them.
+			growTop, growBottom = 0, 0
+			if set.levels > 0 {
+				growTop, growBottom = over, under
+				if base, ok := tl.inkRect(faces, 0); ok {
+					growTop -= maxInt(-base.Min.Y, 0)
+					growBottom -= maxInt(base.Max.Y-height, 0)
+				}
+			}
+		}
+		tl.translate(image.Pt(0, growTop))
+		height += growTop + growBottom
+		if over, under = over-growTop, under-growBottom; (over > 0 || under > 0) && cfg.Verbose && !cfg.Strict {
+			log.Printf("Warning: text is clipped by %dpx at the top and %dpx at the bottom", over, under)
+		}
+	}
+	if cfg.Strict {
+		// Checked again after growing, and on all sides.
+		if ink, ok := tl.inkRect(faces, set.levels); ok && !ink.In(image.Rect(0, 0, width, height)) {
+			return nil, fmt.Errorf("text is clipped: its ink spans %v, outside the %dx%d image", ink, width, height)
+		}
+	}
+
+	final := image.Pt(width, height)
+	if cfg.Repeat {
+		final = image.Pt(cfg.Width, cfg.Height)
+	}
+	if err := checkPixels(final.Mul(maxInt(cfg.Scale, 1)), cfg.MaxPixels); err != nil {
+		return nil, err
+	}
+	return &page{faces, tl, width, height, pad}, nil
 }
 
-func getFreeTypeContext(f *truetype.Font, dpi, size float64, dst *image.RGBA, src image.Image, hintingStr string) *freetype.Context {
-	c := freetype.NewContext()
-	c.SetDPI(dpi)
-	c.SetFont(f)
-	c.SetFontSize(size)
-	c.SetClip(dst.Bounds())
-	c.SetDst(dst)
-	c.SetSrc(src)
+// checkPixels reports an error if an image of the given size would have
+// more than max pixels. A max of 0 or less allows any size.
+func checkPixels(size image.Point, max int) error {
+	if max > 0 && float64(size.X)*float64(size.Y) > float64(max) {
+		return fmt.Errorf("image of %dx%d pixels exceeds the limit of %d pixels", size.X, size.Y, max)
+	}
+	return nil
+}
 
-	switch hintingStr {
-	case "full":
-		c.SetHinting(font.HintingFull)
-	default:
-		c.SetHinting(font.HintingNone)
+// checkEm reports an error if a glyph as big as its em square, em pixels
+// on a side, would have more than max pixels. A max of 0 or less allows
+// any size.
+func checkEm(em float64, max int) error {
+	if max > 0 && em*em > float64(max) {
+		return configErrorf("font size of %.0f pixels per em exceeds the limit of %d pixels for a glyph", em, max)
 	}
-	return c
+	return nil
 }
 
-func renderText(c *freetype.Context, f *truetype.Font, text string, slotW, imgH int, dpi, size float64, verb bool) {
-	opts := truetype.Options{
-		Size: size,
-		DPI:  dpi,
+// loadFonts loads the main font of cfg and its fallbacks, in the order
+// they are tried.
+func (cfg Config) loadFonts() ([]*parsedFont, error) {
+	fontPath := cfg.FontFile
+	if cfg.Font != "" {
+		var err error
+		if fontPath, err = FindFont(cfg.Font); err != nil {
+			return nil, &FontError{cfg.Font, err}
+		}
+	}
+	f, err := loadFont(fontPath, cfg.Verbose)
+	if err != nil {
+		return nil, err
+	}
+	fonts := []*parsedFont{f}
+	for _, path := range cfg.Fallback {
+		fb, err := loadFont(path, cfg.Verbose)
+		if err != nil {
+			return nil, fmt.Errorf("fallback font: %w", err)
+		}
+		fonts = append(fonts, fb)
 	}
-	face := truetype.NewFace(f, &opts)
+	return fonts, nil
+}
 
-	for i, r := range text {
-		advance, ok := face.GlyphAdvance(r)
-		if !ok {
-			log.Printf("Warning: failed to get glyph advance for %q", r)
+// MissingGlyphs returns the distinct characters of text, in order of first
+// appearance, that neither the main font of cfg nor any of its fallbacks
+// has. Text is normalized as cfg.Normalize says, like Render does; spaces
+// and control characters, which draw nothing, are not checked.
+func MissingGlyphs(cfg Config, text string) ([]rune, error) {
+	fonts, err := cfg.loadFonts()
+	if err != nil {
+		return nil, err
+	}
+	if text, err = normalize(text, cfg.Normalize); err != nil {
+		return nil, err
+	}
+	var missing []rune
+	seen := make(map[rune]bool)
+	for _, r := range text {
+		if seen[r] || unicode.IsSpace(r) || unicode.IsControl(r) {
 			continue
 		}
+		seen[r] = true
+		found := false
+		for _, f := range fonts {
+			if f.hasGlyph(r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, r)
+		}
+	}
+	return missing, nil
+}
 
-		glyphWidthPx := int(float64(advance) / 64)
-		if verb {
-			fmt.Printf("Char: %q, Width: %dpx\n", r, glyphWidthPx)
+// padding returns the padding on each side: left and top in Min, right and
+// bottom in Max.
+func (cfg Config) padding() image.Rectangle {
+	side := func(v int) int {
+		if v < 0 {
+			return cfg.Padding
 		}
+		return v
+	}
+	return image.Rect(side(cfg.PadLeft), side(cfg.PadTop), side(cfg.PadRight), side(cfg.PadBottom))
+}
 
-		xPos := i*slotW + (slotW/2 - glyphWidthPx/2)
-		pt := freetype.Pt(xPos, imgH*2/3)
+// progress reports to cfg.Progress, if set.
+func (cfg Config) progress(done, total int) {
+	if cfg.Progress != nil {
+		cfg.Progress(done, total)
+	}
+}
 
-		if _, err := c.DrawString(string(r), pt); err != nil {
-			log.Printf("Error drawing %q: %v", r, err)
+// Colors returns the foreground, background and guideline colors that
+// RenderText uses for cfg.
+func (cfg Config) Colors() (fg, bg, ruler color.RGBA, err error) {
+	fgHex, bgHex, rulerHex := cfg.FG, cfg.BG, ""
+	if cfg.Theme != "" {
+		t, ok := themes[cfg.Theme]
+		if !ok {
+			return fg, bg, ruler, configErrorf("unknown theme %q (want %s)", cfg.Theme, strings.Join(ThemeNames(), ", "))
+		}
+		if fgHex == "" {
+			fgHex = t.fg
+		}
+		if bgHex == "" {
+			// The theme's guidelines are meant for its own background.
+			bgHex, rulerHex = t.bg, t.ruler
 		}
 	}
+	if fg, bg, ruler, err = getColors(cfg.WhiteOnBlack, fgHex, bgHex); err != nil || rulerHex == "" {
+		return fg, bg, ruler, err
+	}
+	ruler, err = parseHexColor(rulerHex)
+	return fg, bg, ruler, err
 }
 
-func saveImage(path string, rgba *image.RGBA) {
-	out, err := os.Create(path)
+func getColors(whiteOnBlack bool, fgHex, bgHex string) (fg, bg, ruler color.RGBA, err error) {
+	fgc, bgc := color.RGBA{0x00, 0x00, 0x00, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}
+	if whiteOnBlack {
+		fgc, bgc = bgc, fgc
+	}
+	if fgHex != "" {
+		c, err := parseHexColor(fgHex)
+		if err != nil {
+			return fg, bg, ruler, fmt.Errorf("invalid foreground color: %w", err)
+		}
+		fgc = c
+	}
+	if bgHex != "" {
+		c, err := parseHexColor(bgHex)
+		if err != nil {
+			return fg, bg, ruler, fmt.Errorf("invalid background color: %w", err)
+		}
+		bgc = c
+	}
+	return fgc, bgc, contrastingRuler(bgc), nil
+}
+
+// parseHexColor parses a color written as #rgb, #rrggbb or #rrggbbaa.
+// The leading '#' is optional.
+func parseHexColor(s string) (color.RGBA, error) {
+	h := strings.TrimPrefix(s, "#")
+	switch len(h) {
+	case 3:
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]}) + "ff"
+	case 6:
+		h += "ff"
+	case 8:
+	default:
+		return color.RGBA{}, configErrorf("%q: expected #rgb, #rrggbb or #rrggbbaa", s)
+	}
+	b, err := hex.DecodeString(h)
 	if err != nil {
-		log.Fatalf("Error creating output file: %v", err)
+		return color.RGBA{}, configErrorf("%q: not a hex color", s)
+	}
+	return color.RGBA{b[0], b[1], b[2], b[3]}, nil
+}
+
+// contrastingRuler derives a guideline color from the background: a
+// slightly darker shade on light backgrounds and a lighter one on dark
+// backgrounds. White and black give the historical #dddddd and #444444.
+func contrastingRuler(bg color.RGBA) color.RGBA {
+	shift := func(v uint8, d int) uint8 {
+		n := int(v) + d
+		if n < 0 {
+			return 0
+		}
+		if n > 0xff {
+			return 0xff
+		}
+		return uint8(n)
+	}
+	d := 0x44
+	if (299*int(bg.R)+587*int(bg.G)+114*int(bg.B))/1000 >= 0x80 {
+		d = -0x22
 	}
-	defer out.Close()
+	return color.RGBA{shift(bg.R, d), shift(bg.G, d), shift(bg.B, d), 0xff}
+}
 
-	bWriter := bufio.NewWriter(out)
-	if err := png.Encode(bWriter, rgba); err != nil {
-		log.Fatalf("Error encoding PNG: %v", err)
+// normalize returns s in the Unicode normalization form called form: NFC
+// composes characters so that precomposed glyphs are used, NFD decomposes
+// them, and none (or an empty form) leaves s as it is.
+func normalize(s, form string) (string, error) {
+	switch strings.ToUpper(form) {
+	case "NFC":
+		return norm.NFC.String(s), nil
+	case "NFD":
+		return norm.NFD.String(s), nil
+	case "NONE", "":
+		return s, nil
 	}
+	return "", configErrorf("invalid normalization %q (want NFC, NFD or none)", form)
+}
 
-	if err := bWriter.Flush(); err != nil {
-		log.Fatalf("Error flushing buffer: %v", err)
+// parseSlotColors parses "index:color" pairs into a map from slot index to
+// color.
+func parseSlotColors(list []string) (map[int]color.RGBA, error) {
+	colors := make(map[int]color.RGBA)
+	for _, item := range list {
+		idx, hex, ok := strings.Cut(item, ":")
+		i, err := strconv.Atoi(strings.TrimSpace(idx))
+		if !ok || err != nil || i < 0 {
+			return nil, configErrorf("slot color %q is not of the form index:#color", item)
+		}
+		c, err := parseHexColor(strings.TrimSpace(hex))
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot color: %w", err)
+		}
+		colors[i] = c
+	}
+	return colors, nil
+}
+
+// splitLines splits text into the lines rendered on successive rows.
+func splitLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
+
+// lineHeight returns the distance in pixels between successive baselines.
+func lineHeight(face font.Face, spacing float64) int {
+	return int(math.Round(float64(face.Metrics().Height) / 64 * spacing))
+}
+
+// createImage allocates a width x imgH canvas filled with bg.
+func createImage(width, imgH int, bg image.Image) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, width, imgH))
+	draw.Draw(rgba, rgba.Bounds(), bg, image.Point{}, draw.Src)
+	return rgba
+}
+
+// guideOverlay is the opacity of guidelines drawn over the text, enough to
+// measure against without hiding the ink under them.
+var guideOverlay = image.NewUniform(color.Alpha{0x80})
+
+// drawGuide draws the guideline r in ruler, replacing what is under it, or
+// blended over it at the opacity of guideOverlay if over is set.
+func drawGuide(rgba *image.RGBA, r image.Rectangle, ruler image.Image, over bool) {
+	if over {
+		draw.DrawMask(rgba, r, ruler, r.Min, guideOverlay, image.Point{}, draw.Over)
+		return
+	}
+	draw.Draw(rgba, r, ruler, r.Min, draw.Src)
+}
+
+// drawGuidelines draws the slot boundaries of tl across the whole image,
+// width pixels wide, skipping all but one boundary in every. Values below 1
+// count as 1. over draws them over the text (see drawGuide).
+func drawGuidelines(rgba *image.RGBA, tl textLayout, ruler image.Image, every, width int, over bool) {
+	every, width = maxInt(every, 1), maxInt(width, 1)
+	b := rgba.Bounds()
+	for i, x := range tl.guidesX {
+		if i%every == 0 {
+			r := image.Rect(x, b.Min.Y, x+width, b.Max.Y)
+			drawGuide(rgba, r, ruler, over)
+		}
+	}
+	for i, y := range tl.guidesY {
+		if i%every == 0 {
+			r := image.Rect(b.Min.X, y, b.Max.X, y+width)
+			drawGuide(rgba, r, ruler, over)
+		}
+	}
+}
+
+// drawBaselines draws a horizontal line across the whole image at every
+// baseline of tl, width pixels thick starting at the baseline row.
+func drawBaselines(rgba *image.RGBA, tl textLayout, ruler image.Image, width int, over bool) {
+	width = maxInt(width, 1)
+	b := rgba.Bounds()
+	for _, y := range tl.baselines() {
+		r := image.Rect(b.Min.X, y, b.Max.X, y+width)
+		drawGuide(rgba, r, ruler, over)
+	}
+}
+
+// newDrawer returns a drawer that paints glyphs of face onto dst in src.
+func newDrawer(dst draw.Image, src image.Image, face font.Face) *font.Drawer {
+	return &font.Drawer{Dst: dst, Src: src, Face: face}
+}
+
+// ConfigError reports a setting of Config that is out of range, not one of
+// the values it accepts or that cannot be combined with the others, as
+// opposed to a problem with the fonts or the text.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// configErrorf formats a *ConfigError.
+func configErrorf(format string, a ...interface{}) error {
+	return &ConfigError{fmt.Errorf(format, a...)}
+}
+
+// MissingGlyphError is the error of a render with Config.Strict set when
+// no font has some of the characters of the text.
+type MissingGlyphError struct {
+	Runes []rune // the characters, in order of first appearance
+}
+
+func (e *MissingGlyphError) Error() string {
+	return fmt.Sprintf("no font has a glyph for %q", string(e.Runes))
+}
+
+// checkMissing logs a warning for every character no font of the chain
+// has, or with cfg.Strict fails with a *MissingGlyphError listing them.
+func (cfg Config) checkMissing(tl textLayout) error {
+	var missing []rune
+	seen := make(map[rune]bool)
+	for _, glyphs := range tl.lines {
+		for _, g := range glyphs {
+			if !g.missing {
+				continue
+			}
+			if !cfg.Strict {
+				log.Printf("Warning: no font has a glyph for %q", g.orig)
+			} else if !seen[g.orig] {
+				seen[g.orig] = true
+				missing = append(missing, g.orig)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingGlyphError{missing}
+	}
+	return nil
+}
+
+// printMetrics writes a table of the glyphs of tl to w: their line, rune,
+// advance and left side bearing, slot (none in proportional mode), pen
+// position and whether any of their ink falls outside bounds.
+func printMetrics(w io.Writer, faces faceChain, tl textLayout, bounds image.Rectangle, proportional bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Line\tChar\tAdvance\tBearing\tSlot\tX\tY\tClipped\t")
+	for row, glyphs := range tl.lines {
+		for i, g := range glyphs {
+			if !g.ok {
+				continue
+			}
+			b, _, _ := faces.faces[g.font].GlyphBounds(g.r)
+			o := tl.origins[row].Add(image.Pt(g.x, g.y))
+			ink := image.Rect(o.X+b.Min.X.Floor(), o.Y+b.Min.Y.Floor(), o.X+b.Max.X.Ceil(), o.Y+b.Max.Y.Ceil())
+			clipped := !ink.Empty() && !ink.In(bounds)
+			slot := strconv.Itoa(i)
+			if proportional {
+				slot = "-"
+			}
+			fmt.Fprintf(tw, "%d\t%q\t%d\t%d\t%s\t%d\t%d\t%t\t\n", row, g.r, g.advance, b.Min.X.Round(), slot, o.X, o.Y, clipped)
+		}
+	}
+	tw.Flush()
+}
+
+// renderText draws the laid-out lines, taking each glyph from its face in
+// faces. If srcs is empty every glyph is drawn with the drawer's current
+// source; otherwise the visible characters cycle through srcs. Line
+// numbers are drawn with gutter and do not advance the cycle. Each glyph
+// is drawn bold more times, one pixel further right each time, to thicken
+// its strokes.
+func renderText(d *font.Drawer, faces faceChain, tl textLayout, srcs []image.Image, gutter image.Image, bold int) {
+	n := 0
+	src := d.Src
+	for row, glyphs := range tl.lines {
+		for _, g := range glyphs {
+			if !g.ok {
+				continue
+			}
+			d.Src = src
+			switch {
+			case g.gutter:
+				d.Src = gutter
+			case len(srcs) > 0 && !unicode.IsSpace(g.r):
+				d.Src = srcs[n%len(srcs)]
+				n++
+			}
+
+			d.Face = faces.faces[g.font]
+			for dx := 0; dx <= bold; dx++ {
+				d.Dot = tl.glyphPt(row, g, image.Pt(dx, 0))
+				d.DrawString(string(g.r))
+			}
+		}
 	}
 }
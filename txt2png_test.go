@@ -0,0 +1,59 @@
+package txt2png
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateRejectsBadSettings(t *testing.T) {
+	if err := testConfig("ok").Validate(); err != nil {
+		t.Fatalf("valid settings: %v", err)
+	}
+	for name, set := range map[string]func(*Config){
+		"size":         func(c *Config) { c.Size = 0 },
+		"dpi":          func(c *Config) { c.DPI = -72 },
+		"height":       func(c *Config) { c.Height = 0 },
+		"slot width":   func(c *Config) { c.SlotWidth = -1 },
+		"tab width":    func(c *Config) { c.TabWidth = 0 },
+		"padding":      func(c *Config) { c.Padding = -200 },
+		"pad side":     func(c *Config) { c.PadLeft = -2 },
+		"line spacing": func(c *Config) { c.LineSpacing = -5 },
+		"hinting":      func(c *Config) { c.Hinting = "ful" },
+		"halign":       func(c *Config) { c.HAlign = "middle" },
+		"align":        func(c *Config) { c.Align = "c,x" },
+		"valign":       func(c *Config) { c.VAlign = "x" },
+		"anchor":       func(c *Config) { c.Anchor = "up" },
+		"theme":        func(c *Config) { c.Theme = "x" },
+		"color":        func(c *Config) { c.BG = "#12" },
+		"rotate":       func(c *Config) { c.Rotate = 45 },
+		"normalize":    func(c *Config) { c.Normalize = "NFKC" },
+		"justify":      func(c *Config) { c.Justify = true },
+		"cap height":   func(c *Config) { c.CapHeight, c.FitWidth = 20, 100 },
+	} {
+		cfg := testConfig("bad")
+		set(&cfg)
+		var ce *ConfigError
+		if err := cfg.Validate(); !errors.As(err, &ce) {
+			t.Errorf("%s: Validate returned %v, want a *ConfigError", name, err)
+		}
+		// Rendering fails the same way, before loading the missing font.
+		cfg.FontFile = "missing.ttf"
+		if _, err := Render(cfg); !errors.As(err, &ce) {
+			t.Errorf("%s: Render returned %v, want a *ConfigError", name, err)
+		}
+	}
+}
+
+func TestHugeFontSizeFailsCleanly(t *testing.T) {
+	cfg := testConfig("W")
+	cfg.Size = 20000
+	var ce *ConfigError
+	if _, err := Render(cfg); !errors.As(err, &ce) || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Fatalf("Render returned %v, want the pixel limit as a *ConfigError", err)
+	}
+	cfg.Size, cfg.MaxPixels = 200, 200*200
+	if _, err := Render(cfg); err != nil {
+		t.Fatalf("a size at the limit: %v", err)
+	}
+}